@@ -0,0 +1,138 @@
+package omnitrail
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/edwarnicke/gitoid"
+	"github.com/omnibor/omnibor-go"
+)
+
+// ADGSet holds sha1 and sha256 ADGs keyed by their gitoid. File gitoids are
+// included with an empty ADG, the same way a Factory reports them.
+type ADGSet struct {
+	Sha1   map[string]string
+	Sha256 map[string]string
+}
+
+func NewADGSet() *ADGSet {
+	return &ADGSet{
+		Sha1:   make(map[string]string),
+		Sha256: make(map[string]string),
+	}
+}
+
+func (s *ADGSet) Sha1ADGs() map[string]string {
+	return s.Sha1
+}
+
+func (s *ADGSet) Sha256ADGs() map[string]string {
+	return s.Sha256
+}
+
+func newArtifactTree(algorithm string) (omnibor.ArtifactTree, error) {
+	switch algorithm {
+	case "gitoid:sha1":
+		return omnibor.NewSha1OmniBOR(), nil
+	case "gitoid:sha256":
+		return omnibor.NewSha256OmniBOR(), nil
+	}
+	return nil, fmt.Errorf("unsupported ADG algorithm %s", algorithm)
+}
+
+func elementGitoid(e *Element, algorithm string) string {
+	switch algorithm {
+	case "gitoid:sha1":
+		return e.Sha1Gitoid
+	case "gitoid:sha256":
+		return e.Sha256Gitoid
+	}
+	return ""
+}
+
+func setElementGitoid(e *Element, algorithm string, gitoid string) {
+	switch algorithm {
+	case "gitoid:sha1":
+		e.Sha1Gitoid = gitoid
+	case "gitoid:sha256":
+		e.Sha256Gitoid = gitoid
+	}
+}
+
+// buildADGs computes the ADGs of the given directories from the gitoids of
+// their children in the mapping. Child directories are computed first so
+// their identities can be referenced by their parents.
+func buildADGs(mapping map[string]*Element, directories []string, algorithm string) (map[string]omnibor.ArtifactTree, error) {
+	trees := make(map[string]omnibor.ArtifactTree, len(directories))
+	for _, dir := range directories {
+		tree, err := newArtifactTree(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		trees[dir] = tree
+	}
+
+	// sort the directories from the longest length to the shortest length
+	keys := append([]string{}, directories...)
+	sort.Slice(keys, func(i, j int) bool {
+		return len(keys[i]) > len(keys[j])
+	})
+
+	for path, element := range mapping {
+		tree, ok := trees[filepath.Dir(path)]
+		if !ok || path == filepath.Dir(path) {
+			continue
+		}
+		if _, isDir := trees[path]; isDir {
+			continue
+		}
+		if err := tree.AddExistingReference(elementGitoid(element, algorithm)); err != nil {
+			return nil, fmt.Errorf("%s of %s: %w", algorithm, path, err)
+		}
+	}
+
+	for _, key := range keys {
+		dir := filepath.Dir(key)
+		if tree, ok := trees[dir]; ok && dir != key {
+			if err := tree.AddExistingReference(trees[key].Identity()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return trees, nil
+}
+
+// add records directory ADGs and the gitoids of files in the set.
+func (s *ADGSet) add(algorithm string, trees map[string]omnibor.ArtifactTree, mapping map[string]*Element) {
+	m := s.Sha1
+	if algorithm == "gitoid:sha256" {
+		m = s.Sha256
+	}
+	for path, element := range mapping {
+		if _, ok := trees[path]; ok {
+			continue
+		}
+		if gitoid := elementGitoid(element, algorithm); gitoid != "" {
+			m[gitoid] = ""
+		}
+	}
+	for _, tree := range trees {
+		m[tree.Identity()] = tree.String()
+	}
+}
+
+// adgIdentity returns the sha1 or sha256 gitoid of an ADG body.
+func adgIdentity(body string, sha256 bool) string {
+	options := []gitoid.Option{gitoid.WithContentLength(int64(len(body)))}
+	if sha256 {
+		options = append(options, gitoid.WithSha256())
+	}
+	id, err := gitoid.New(strings.NewReader(body), options...)
+	if err != nil {
+		// reading from a string cannot fail
+		panic(err)
+	}
+	return id.String()
+}
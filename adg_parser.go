@@ -0,0 +1,140 @@
+package omnitrail
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ADGParseError reports a malformed or inconsistent ADG or Input Manifest at a
+// line of the input.
+type ADGParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ADGParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// ParseADGString parses the output of FormatADGString.
+func ParseADGString(s string) (*ADGSet, error) {
+	return ParseADGs(strings.NewReader(s))
+}
+
+// ParseADGs reads ADGs in the format of FormatADGString: sha1 ADGs, a "----"
+// separator and sha256 ADGs. Each ADG is its identity, its body, an empty
+// line and a "--" terminator. The identity of every ADG is checked by
+// recomputing the gitoid of its body.
+func ParseADGs(r io.Reader) (*ADGSet, error) {
+	const (
+		expectIdentity = iota
+		expectBody
+		expectTerminator
+	)
+
+	set := NewADGSet()
+	adgs := set.Sha1
+	hashLength := 40
+	separated := false
+	state := expectIdentity
+
+	var identity, body string
+	identityLine := 0
+	lineNumber := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		switch state {
+		case expectIdentity:
+			if line == "----" {
+				if separated {
+					return nil, &ADGParseError{Line: lineNumber, Msg: "unexpected second ---- separator"}
+				}
+				separated = true
+				adgs = set.Sha256
+				hashLength = 64
+				continue
+			}
+			if err := checkHex(line, hashLength); err != nil {
+				return nil, &ADGParseError{Line: lineNumber, Msg: fmt.Sprintf("invalid identity: %v", err)}
+			}
+			if _, ok := adgs[line]; ok {
+				return nil, &ADGParseError{Line: lineNumber, Msg: fmt.Sprintf("duplicate ADG %s", line)}
+			}
+			identity, body, identityLine = line, "", lineNumber
+			state = expectBody
+		case expectBody:
+			if line == "" {
+				if body == "" {
+					return nil, &ADGParseError{Line: lineNumber, Msg: "empty ADG"}
+				}
+				state = expectTerminator
+				continue
+			}
+			if err := checkReference(line, hashLength); err != nil {
+				return nil, &ADGParseError{Line: lineNumber, Msg: err.Error()}
+			}
+			body += line + "\n"
+		case expectTerminator:
+			if line != "--" {
+				return nil, &ADGParseError{Line: lineNumber, Msg: fmt.Sprintf("expected --, got %q", line)}
+			}
+			if actual := adgIdentity(body, hashLength == 64); actual != identity {
+				return nil, &ADGParseError{Line: identityLine, Msg: fmt.Sprintf("ADG %s has gitoid %s", identity, actual)}
+			}
+			adgs[identity] = body
+			state = expectIdentity
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if state != expectIdentity {
+		return nil, &ADGParseError{Line: lineNumber, Msg: "unterminated ADG"}
+	}
+	if !separated {
+		return nil, &ADGParseError{Line: lineNumber, Msg: "missing ---- separator"}
+	}
+	return set, nil
+}
+
+// checkReference validates an ADG line of the form "blob <gitoid>", optionally
+// followed by " bom <gitoid>".
+func checkReference(line string, hashLength int) error {
+	fields := strings.Split(line, " ")
+	if len(fields) != 2 && len(fields) != 4 {
+		return fmt.Errorf("invalid reference %q", line)
+	}
+	if fields[0] != "blob" {
+		return fmt.Errorf("invalid reference type %q", fields[0])
+	}
+	if err := checkHex(fields[1], hashLength); err != nil {
+		return fmt.Errorf("invalid reference: %v", err)
+	}
+	if len(fields) == 4 {
+		if fields[2] != "bom" {
+			return fmt.Errorf("invalid reference %q", line)
+		}
+		if err := checkHex(fields[3], hashLength); err != nil {
+			return fmt.Errorf("invalid bom reference: %v", err)
+		}
+	}
+	return nil
+}
+
+func checkHex(s string, length int) error {
+	if len(s) != length {
+		return fmt.Errorf("%q is not %d hex characters", s, length)
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return fmt.Errorf("%q is not hex", s)
+	}
+	if strings.ToLower(s) != s {
+		return fmt.Errorf("%q is not lowercase", s)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package omnitrail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGoldenADGs(t *testing.T) {
+	files, err := filepath.Glob("./test/*.adg")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	for _, file := range files {
+		expected, err := os.ReadFile(file)
+		require.NoError(t, err)
+		adgs, err := ParseADGString(string(expected))
+		require.NoError(t, err, file)
+		assert.Equal(t, string(expected), FormatADGString(adgs), file)
+	}
+}
+
+func TestParseADGs(t *testing.T) {
+	b, err := os.ReadFile("./test/deep.adg")
+	require.NoError(t, err)
+	adgs, err := ParseADGString(string(b))
+	require.NoError(t, err)
+	assert.Len(t, adgs.Sha1ADGs(), 3)
+	assert.Len(t, adgs.Sha256ADGs(), 3)
+	assert.Equal(t, "blob 30d67d4672d5c05833b7192cc77a79eaafb5c7ad\n", adgs.Sha1ADGs()["b51452d06f9e8b948089762da313b43973cbd6a6"])
+}
+
+func TestParseADGErrors(t *testing.T) {
+	b, err := os.ReadFile("./test/deep.adg")
+	require.NoError(t, err)
+	deep := string(b)
+
+	tests := map[string]struct {
+		input string
+		line  int
+	}{
+		"tampered body": {
+			input: strings.Replace(deep, "blob 93ca1422a8da0a9effc465eccbcb17e23015542d", "blob 93ca1422a8da0a9effc465eccbcb17e23015542e", 1),
+			line:  1,
+		},
+		"sha1 reference in sha256 section": {
+			input: strings.Replace(deep, "blob 2da00b9c51e5e7554c44a3929f9f8cee3c4e742d4f71bec0d4e1d37256d17207", "blob 08219db9b0969fa29cf16fd04df4a63964da0b69", 1),
+			line:  17,
+		},
+		"missing terminator": {
+			input: strings.Replace(deep, "\n--\nb514", "\nb514", 1),
+			line:  5,
+		},
+		"missing separator": {
+			input: "afc6c552cd2595009cf7847777ad5897d0abe46a\nblob 93ca1422a8da0a9effc465eccbcb17e23015542d\nblob f6c2e0f6ac10055527287a21db1ad39424bf3b99\n\n--\n",
+			line:  5,
+		},
+		"unterminated": {
+			input: "----\n3f8d7b5b500f4db43c40c4586f754e4702c1a7a3d61509867f1af37fae04fa18\n",
+			line:  2,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseADGString(tt.input)
+			var parseErr *ADGParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, tt.line, parseErr.Line, parseErr.Error())
+		})
+	}
+}
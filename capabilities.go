@@ -0,0 +1,254 @@
+package omnitrail
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterOptionalPlugin("capabilities", NewCapabilitiesPlugin)
+}
+
+// struct vfs_cap_data revisions and flags of linux/capability.h
+const (
+	vfsCapRevisionMask  = 0xFF000000
+	vfsCapRevision1     = 0x01000000
+	vfsCapRevision2     = 0x02000000
+	vfsCapRevision3     = 0x03000000
+	vfsCapFlagEffective = 0x000001
+)
+
+// capabilityNames are the names of the capabilities by number, as printed by
+// getcap.
+var capabilityNames = []string{
+	"cap_chown",
+	"cap_dac_override",
+	"cap_dac_read_search",
+	"cap_fowner",
+	"cap_fsetid",
+	"cap_kill",
+	"cap_setgid",
+	"cap_setuid",
+	"cap_setpcap",
+	"cap_linux_immutable",
+	"cap_net_bind_service",
+	"cap_net_broadcast",
+	"cap_net_admin",
+	"cap_net_raw",
+	"cap_ipc_lock",
+	"cap_ipc_owner",
+	"cap_sys_module",
+	"cap_sys_rawio",
+	"cap_sys_chroot",
+	"cap_sys_ptrace",
+	"cap_sys_pacct",
+	"cap_sys_admin",
+	"cap_sys_boot",
+	"cap_sys_nice",
+	"cap_sys_resource",
+	"cap_sys_time",
+	"cap_sys_tty_config",
+	"cap_mknod",
+	"cap_lease",
+	"cap_audit_write",
+	"cap_audit_control",
+	"cap_setfcap",
+	"cap_mac_override",
+	"cap_mac_admin",
+	"cap_syslog",
+	"cap_wake_alarm",
+	"cap_block_suspend",
+	"cap_audit_read",
+	"cap_perfmon",
+	"cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+func capabilityName(n int) string {
+	if n < len(capabilityNames) {
+		return capabilityNames[n]
+	}
+	// capabilities newer than this list are printed by number, as getcap does
+	return strconv.Itoa(n)
+}
+
+// CapabilitiesInvalid is the status of a security.capability xattr that has
+// an unknown revision or the wrong size for its revision.
+const CapabilitiesInvalid = "invalid"
+
+// Capabilities are the file capabilities of the security.capability xattr.
+// A file with the effective flag set runs with its permitted capabilities
+// effective, so Effective is then the union of Permitted and Inheritable, as
+// reported by libcap. RootID is the root user of the user namespace a
+// version 3 capability was set in.
+type Capabilities struct {
+	Version     string   `json:"version"`
+	Permitted   []string `json:"permitted,omitempty"`
+	Inheritable []string `json:"inheritable,omitempty"`
+	Effective   []string `json:"effective,omitempty"`
+	RootID      string   `json:"rootid,omitempty"`
+	// Text is the capabilities in the text form of cap_from_text(3), for
+	// example "cap_net_raw=ep". getcap may write capabilities with
+	// different flags relative to each other, as in
+	// "cap_sys_nice=eip cap_net_raw+ep", which is the same as
+	// "cap_net_raw=ep cap_sys_nice=eip".
+	Text string `json:"text"`
+	// Status is CapabilitiesInvalid for xattrs that cannot be decoded, which
+	// are recorded as Value instead
+	Status string `json:"status,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+// CapabilitiesPlugin decodes the file capabilities of every file, so that
+// capability grants can be reviewed like setuid bits.
+type CapabilitiesPlugin struct {
+	files     map[string]*Capabilities
+	AllowList []string
+}
+
+func NewCapabilitiesPlugin() Plugin {
+	return &CapabilitiesPlugin{
+		files: make(map[string]*Capabilities),
+	}
+}
+
+func (plug *CapabilitiesPlugin) Add(path string) error {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		// broken symlinks are ignored, as in the file plugin
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return nil
+	}
+	value, ok, err := getXattr(path, "security.capability")
+	if err != nil || !ok {
+		return err
+	}
+	plug.files[path] = capabilitiesOf(value)
+	return nil
+}
+
+// capabilitiesOf decodes a security.capability xattr, or records it as
+// invalid. The kernel refuses to set invalid capabilities, but file systems
+// mounted from images or other hosts may still carry them.
+func capabilitiesOf(value []byte) *Capabilities {
+	capabilities, err := decodeCapabilities(value)
+	if err != nil {
+		return &Capabilities{Status: CapabilitiesInvalid, Value: hex.EncodeToString(value)}
+	}
+	return capabilities
+}
+
+func (plug *CapabilitiesPlugin) Store(envelope *Envelope) error {
+	envelope.Header.Features["capabilities"] = Feature{}
+	for path, capabilities := range plug.files {
+		if element, ok := envelope.Mapping[path]; ok {
+			element.Capabilities = capabilities
+		}
+	}
+	return nil
+}
+
+func (plug *CapabilitiesPlugin) Sha1ADG(map[string]string) {}
+
+func (plug *CapabilitiesPlugin) Sha256ADG(map[string]string) {}
+
+func (plug *CapabilitiesPlugin) SetAllowList(allowList []string) {
+	plug.AllowList = allowList
+}
+
+// decodeCapabilities decodes struct vfs_cap_data, or struct vfs_ns_cap_data
+// for version 3, which are stored little endian:
+//
+//	__le32 magic_etc; struct { __le32 permitted; __le32 inheritable; } data[]; __le32 rootid;
+func decodeCapabilities(value []byte) (*Capabilities, error) {
+	if len(value) < 4 {
+		return nil, fmt.Errorf("truncated capabilities")
+	}
+	magic := binary.LittleEndian.Uint32(value)
+	var words, size int
+	switch magic & vfsCapRevisionMask {
+	case vfsCapRevision1:
+		words, size = 1, 12
+	case vfsCapRevision2:
+		words, size = 2, 20
+	case vfsCapRevision3:
+		words, size = 2, 24
+	default:
+		return nil, fmt.Errorf("unknown capability revision 0x%08x", magic&vfsCapRevisionMask)
+	}
+	if len(value) != size {
+		return nil, fmt.Errorf("capability revision %d has %d bytes, expected %d", magic>>24, len(value), size)
+	}
+
+	var permitted, inheritable uint64
+	for i := 0; i < words; i++ {
+		permitted |= uint64(binary.LittleEndian.Uint32(value[4+8*i:])) << (32 * i)
+		inheritable |= uint64(binary.LittleEndian.Uint32(value[8+8*i:])) << (32 * i)
+	}
+	effective := uint64(0)
+	if magic&vfsCapFlagEffective != 0 {
+		effective = permitted | inheritable
+	}
+
+	c := &Capabilities{
+		Version:     strconv.Itoa(int(magic >> 24)),
+		Permitted:   capabilitySet(permitted),
+		Inheritable: capabilitySet(inheritable),
+		Effective:   capabilitySet(effective),
+		Text:        capabilityText(permitted, inheritable, effective),
+	}
+	if magic&vfsCapRevisionMask == vfsCapRevision3 {
+		c.RootID = strconv.FormatUint(uint64(binary.LittleEndian.Uint32(value[20:])), 10)
+	}
+	return c, nil
+}
+
+func capabilitySet(bits uint64) []string {
+	var names []string
+	for n := 0; n < 64; n++ {
+		if bits&(1<<n) != 0 {
+			names = append(names, capabilityName(n))
+		}
+	}
+	return names
+}
+
+// capabilityText groups the capabilities by their flags, as in
+// "cap_net_admin,cap_net_raw=ep cap_sys_nice=p".
+func capabilityText(permitted, inheritable, effective uint64) string {
+	var groups []string
+	names := make(map[string][]string)
+	for n := 0; n < 64; n++ {
+		flags := ""
+		if effective&(1<<n) != 0 {
+			flags += "e"
+		}
+		if inheritable&(1<<n) != 0 {
+			flags += "i"
+		}
+		if permitted&(1<<n) != 0 {
+			flags += "p"
+		}
+		if flags == "" {
+			continue
+		}
+		if _, ok := names[flags]; !ok {
+			groups = append(groups, flags)
+		}
+		names[flags] = append(names[flags], capabilityName(n))
+	}
+	parts := make([]string, 0, len(groups))
+	for _, flags := range groups {
+		parts = append(parts, strings.Join(names[flags], ",")+"="+flags)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package omnitrail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilitiesPlugin(t *testing.T) {
+	dir := t.TempDir()
+	ping := filepath.Join(dir, "ping")
+	require.NoError(t, os.WriteFile(ping, []byte("#!/bin/sh\n"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "plain"), []byte("#!/bin/sh\n"), 0755))
+	// cap_net_raw+ep
+	setXattr(t, ping, "security.capability", "0100000200200000000000000000000000000000")
+
+	trail := NewTrail(WithPlugins("capabilities"))
+	require.NoError(t, trail.Add(dir))
+	envelope := trail.Envelope()
+	assert.Contains(t, envelope.Header.Features, "capabilities")
+	assert.Equal(t, "cap_net_raw=ep", envelope.Mapping[ping].Capabilities.Text)
+
+	idx := NewIndex(envelope)
+	assert.Equal(t, []string{ping}, collect(idx.ByCapability("")))
+	assert.Equal(t, []string{ping}, collect(idx.ByCapability("cap_net_raw")))
+	assert.Empty(t, collect(idx.ByCapability("cap_sys_admin")))
+}
@@ -0,0 +1,78 @@
+package omnitrail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The first xattrs were written by setcap, and their text is what getcap
+// prints.
+func TestDecodeCapabilities(t *testing.T) {
+	for name, tt := range map[string]struct {
+		value    string
+		expected Capabilities
+	}{
+		"cap_net_raw+ep": {"0100000200200000000000000000000000000000", Capabilities{
+			Version:   "2",
+			Permitted: []string{"cap_net_raw"},
+			Effective: []string{"cap_net_raw"},
+			Text:      "cap_net_raw=ep",
+		}},
+		"cap_setuid,cap_bpf+ei": {"0100000200000000800000000000000080000000", Capabilities{
+			Version:     "2",
+			Inheritable: []string{"cap_setuid", "cap_bpf"},
+			Effective:   []string{"cap_setuid", "cap_bpf"},
+			Text:        "cap_setuid,cap_bpf=ei",
+		}},
+		"cap_net_admin,cap_net_raw+p cap_sys_nice+ip": {"0000000200308000000080000000000000000000", Capabilities{
+			Version:     "2",
+			Permitted:   []string{"cap_net_admin", "cap_net_raw", "cap_sys_nice"},
+			Inheritable: []string{"cap_sys_nice"},
+			Text:        "cap_net_admin,cap_net_raw=p cap_sys_nice=ip",
+		}},
+		"rootid": {"0100000300200000000000000000000000000000e8030000", Capabilities{
+			Version:   "3",
+			Permitted: []string{"cap_net_raw"},
+			Effective: []string{"cap_net_raw"},
+			RootID:    "1000",
+			Text:      "cap_net_raw=ep",
+		}},
+		"revision 1": {"010000010000040000000000", Capabilities{
+			Version:   "1",
+			Permitted: []string{"cap_sys_chroot"},
+			Effective: []string{"cap_sys_chroot"},
+			Text:      "cap_sys_chroot=ep",
+		}},
+		"unknown capability": {"0000000200000000000000000000010000000000", Capabilities{
+			Version:   "2",
+			Permitted: []string{"48"},
+			Text:      "48=p",
+		}},
+	} {
+		capabilities, err := decodeCapabilities(mustDecodeHex(t, tt.value))
+		require.NoError(t, err, name)
+		assert.Equal(t, &tt.expected, capabilities, name)
+	}
+
+	for name, value := range map[string]string{
+		"empty":            "",
+		"unknown revision": "0000000400200000000000000000000000000000",
+		"bad size":         "01000002002000000000000000000000",
+		"truncated v3":     "0100000300200000000000000000000000000000",
+	} {
+		_, err := decodeCapabilities(mustDecodeHex(t, value))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestInvalidCapabilities(t *testing.T) {
+	for name, value := range map[string]string{
+		"truncated v2":     "01000002002000000000000000000000",
+		"unknown revision": "0000000400200000000000000000000000000000",
+	} {
+		assert.Equal(t, &Capabilities{Status: CapabilitiesInvalid, Value: value}, capabilitiesOf(mustDecodeHex(t, value)), name)
+	}
+	assert.Equal(t, "cap_net_raw=ep", capabilitiesOf(mustDecodeHex(t, "0100000200200000000000000000000000000000")).Text)
+}
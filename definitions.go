@@ -7,45 +7,89 @@ type Envelope struct {
 
 type Header struct {
 	Features map[string]Feature `json:"features"`
+	// Policy is the algorithm policy the trail was created under, if any
+	Policy *Policy `json:"policy,omitempty"`
 }
 
 type Feature struct {
 	Algorithms []string `json:"algorithms,omitempty"`
+	// XattrAllow and XattrDeny are the extended attribute filters the posix
+	// feature was recorded with, if any were set
+	XattrAllow []string `json:"xattr_allow,omitempty"`
+	XattrDeny  []string `json:"xattr_deny,omitempty"`
+	// ExcludeTimestamps is set if the posix feature was recorded without
+	// timestamps
+	ExcludeTimestamps bool `json:"exclude_timestamps,omitempty"`
 }
 
 type Element struct {
-	Type         string `json:"type"`
-	Sha1         string `json:"sha1,omitempty"`
-	Sha256       string `json:"sha256,omitempty"`
-	Sha1Gitoid   string `json:"gitoid:sha1,omitempty"`
-	Sha256Gitoid string `json:"gitoid:sha256,omitempty"`
-	Posix        *Posix `json:"posix,omitempty"`
+	Type          string        `json:"type"`
+	Sha1          string        `json:"sha1,omitempty"`
+	Sha256        string        `json:"sha256,omitempty"`
+	Sha1Gitoid    string        `json:"gitoid:sha1,omitempty"`
+	Sha256Gitoid  string        `json:"gitoid:sha256,omitempty"`
+	Sha1GitTree   string        `json:"gittree:sha1,omitempty"`
+	Sha256GitTree string        `json:"gittree:sha256,omitempty"`
+	NarSha256     string        `json:"nar:sha256,omitempty"`
+	H1            string        `json:"h1,omitempty"`
+	SWHID         string        `json:"swhid,omitempty"`
+	Posix         *Posix        `json:"posix,omitempty"`
+	Derivation    *Derivation   `json:"derivation,omitempty"`
+	FsVerity      *FsVerity     `json:"fsverity,omitempty"`
+	IMA           *IMA          `json:"ima,omitempty"`
+	Capabilities  *Capabilities `json:"capabilities,omitempty"`
+	// Digests holds the digests that have no field of their own, keyed by
+	// algorithm, for example "sha384". They are serialized as top-level keys
+	// next to the fields.
+	Digests map[string]string `json:"-"`
 }
 
 type Posix struct {
-	ATime              string `json:"atime,omitempty"`
-	CTime              string `json:"ctime,omitempty"`
-	CreationTime       string `json:"creation_time,omitempty"`
-	ExtendedAttributes string `json:"extended_attributes,omitempty"`
-	FileDeviceID       string `json:"file_device_id,omitempty"`
-	FileFlags          string `json:"file_flags,omitempty"`
-	FileInode          string `json:"file_inode,omitempty"`
-	FileSystemID       string `json:"file_system_id,omitempty"`
-	FileType           string `json:"file_type,omitempty"`
-	HardLinkCount      string `json:"hard_link_count,omitempty"`
-	MTime              string `json:"mtime,omitempty"`
-	MetadataCTime      string `json:"metadata_ctime,omitempty"`
-	OwnerGID           string `json:"owner_gid,omitempty"`
-	OwnerUID           string `json:"owner_uid,omitempty"`
-	Permissions        string `json:"permissions,omitempty"`
-	Size               string `json:"size,omitempty"`
+	ATime        string `json:"atime,omitempty"`
+	CTime        string `json:"ctime,omitempty"`
+	CreationTime string `json:"creation_time,omitempty"`
+	// ExtendedAttributes maps the name of every extended attribute to its
+	// base64 encoded value
+	ExtendedAttributes map[string]string `json:"extended_attributes,omitempty"`
+	FileDeviceID       string            `json:"file_device_id,omitempty"`
+	FileFlags          string            `json:"file_flags,omitempty"`
+	FileInode          string            `json:"file_inode,omitempty"`
+	FileSystemID       string            `json:"file_system_id,omitempty"`
+	FileSystemType     string            `json:"file_system_type,omitempty"`
+	FileType           string            `json:"file_type,omitempty"`
+	HardLinkCount      string            `json:"hard_link_count,omitempty"`
+	MTime              string            `json:"mtime,omitempty"`
+	MetadataCTime      string            `json:"metadata_ctime,omitempty"`
+	OwnerGID           string            `json:"owner_gid,omitempty"`
+	OwnerUID           string            `json:"owner_uid,omitempty"`
+	Permissions        string            `json:"permissions,omitempty"`
+	Size               string            `json:"size,omitempty"`
+	// Symlink describes the link itself if the path is a symlink, while the
+	// other fields describe its target
+	Symlink *PosixSymlink `json:"symlink,omitempty"`
+}
+
+// PosixSymlink is the lstat of a symlink.
+type PosixSymlink struct {
+	FileDeviceID  string `json:"file_device_id,omitempty"`
+	FileInode     string `json:"file_inode,omitempty"`
+	HardLinkCount string `json:"hard_link_count,omitempty"`
+	OwnerGID      string `json:"owner_gid,omitempty"`
+	OwnerUID      string `json:"owner_uid,omitempty"`
+	Permissions   string `json:"permissions,omitempty"`
+	Target        string `json:"target"`
 }
 
 type Factory interface {
+	ADGProvider
 	Add(originalPath string) error
+	AddDerivation(output string, inputs []string, option ...DerivationOption) error
+	Envelope() *Envelope
+}
+
+type ADGProvider interface {
 	Sha1ADGs() map[string]string
 	Sha256ADGs() map[string]string
-	Envelope() *Envelope
 }
 
 type Option func(o *Options)
@@ -53,6 +97,30 @@ type Option func(o *Options)
 type Options struct {
 	Sha1Enabled   bool
 	Sha256Enabled bool
+	// FileAlgorithms are computed for files in addition to the sha1, sha256
+	// and gitoid digests, for example "sha384"
+	FileAlgorithms []string
+	// DirectoryAlgorithms are computed for directories in addition to the
+	// gitoid ADGs, for example "gittree:sha1"
+	DirectoryAlgorithms []string
+	// Plugins are the optional plugins to load, for example "swhid"
+	Plugins []string
+	// Policy is the name of the registered policy restricting the
+	// algorithms, for example "fips"
+	Policy string
+	// FsVerityBlockSize and FsVeritySalt are the Merkle tree parameters of
+	// the fsverity plugin
+	FsVerityBlockSize int
+	FsVeritySalt      []byte
+	// ExcludeVolatileTimestamps leaves out the access, change and birth
+	// times of the posix plugin
+	ExcludeVolatileTimestamps bool
+	// ExcludeTimestamps leaves out every timestamp of the posix plugin
+	ExcludeTimestamps bool
+	// XattrAllow and XattrDeny select the extended attributes of the posix
+	// plugin by namespace or name
+	XattrAllow []string
+	XattrDeny  []string
 }
 
 type Plugin interface {
@@ -62,3 +130,18 @@ type Plugin interface {
 	Sha256ADG(map[string]string)
 	SetAllowList([]string)
 }
+
+// ConfigurablePlugin is implemented by plugins that take options from
+// NewTrail.
+type ConfigurablePlugin interface {
+	Plugin
+	Configure(o *Options)
+}
+
+// AlgorithmPlugin is implemented by plugins that produce digests, so that
+// NewTrail refuses them under a policy that does not allow their algorithms.
+// Algorithms is called after Configure.
+type AlgorithmPlugin interface {
+	Plugin
+	Algorithms() []string
+}
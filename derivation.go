@@ -0,0 +1,206 @@
+package omnitrail
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Derivation records the inputs an element was built from. Inputs are slash
+// separated paths relative to the directory of the element, so they stay
+// valid when a trail is compared or extracted under another root. The
+// gitoids identify the ADG listing the inputs.
+type Derivation struct {
+	Inputs       []string `json:"inputs"`
+	Sha1Gitoid   string   `json:"gitoid:sha1,omitempty"`
+	Sha256Gitoid string   `json:"gitoid:sha256,omitempty"`
+}
+
+type DerivationOption func(o *DerivationOptions)
+
+type DerivationOptions struct {
+	// InputManifests adds " bom <gitoid>" to inputs that have an ADG of their
+	// own, such as directories and outputs of other derivations
+	InputManifests bool
+}
+
+func WithInputManifests() DerivationOption {
+	return func(o *DerivationOptions) {
+		o.InputManifests = true
+	}
+}
+
+// AddDerivation records that the file at output was built from inputs. All
+// paths must have been added to the trail before. An ADG listing the gitoids
+// of the inputs is created for every gitoid algorithm of the output, and
+// deriving an output again replaces its previous inputs. An output whose ADG
+// is referenced by another derivation WithInputManifests cannot be derived
+// again, as that reference would dangle.
+func (factory *factoryImpl) AddDerivation(output string, inputs []string, option ...DerivationOption) error {
+	o := &DerivationOptions{}
+	for _, opt := range option {
+		opt(o)
+	}
+
+	output, err := filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+	element, ok := factory.envelope.Mapping[output]
+	if !ok {
+		return fmt.Errorf("output %s is not in the trail", output)
+	}
+	if element.Type != "file" {
+		return fmt.Errorf("output %s is a %s, not a file", output, element.Type)
+	}
+	if referrer := factory.derivationReferrer(output); referrer != "" {
+		return fmt.Errorf("output %s cannot be derived again, %s references its ADG", output, referrer)
+	}
+
+	derivation := &Derivation{Inputs: []string{}}
+	inputElements := make(map[string]*Element)
+	for _, input := range inputs {
+		input, err := filepath.Abs(input)
+		if err != nil {
+			return err
+		}
+		if input == output {
+			return fmt.Errorf("%s cannot be derived from itself", output)
+		}
+		inputElement, ok := factory.envelope.Mapping[input]
+		if !ok {
+			return fmt.Errorf("input %s is not in the trail", input)
+		}
+		rel, err := filepath.Rel(filepath.Dir(output), input)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if _, ok := inputElements[rel]; !ok {
+			derivation.Inputs = append(derivation.Inputs, rel)
+			inputElements[rel] = inputElement
+		}
+	}
+	if len(inputElements) == 0 {
+		return fmt.Errorf("output %s has no inputs", output)
+	}
+	sort.Strings(derivation.Inputs)
+
+	adgs := NewADGSet()
+	var algorithms []string
+	for _, algorithm := range []string{"gitoid:sha1", "gitoid:sha256"} {
+		if elementGitoid(element, algorithm) == "" {
+			continue
+		}
+		// inputs with the same content share a reference, which takes the
+		// bom of the first of them that has one
+		references := make(map[string]string)
+		for _, rel := range derivation.Inputs {
+			inputElement := inputElements[rel]
+			gitoid := elementGitoid(inputElement, algorithm)
+			if gitoid == "" {
+				return fmt.Errorf("input %s has no %s", rel, algorithm)
+			}
+			bom := ""
+			if o.InputManifests {
+				bom = inputManifest(inputElement, algorithm)
+			}
+			if references[gitoid] == "" {
+				references[gitoid] = bom
+			}
+		}
+		body := derivationADG(references)
+		identity := adgIdentity(body, algorithm == "gitoid:sha256")
+		if algorithm == "gitoid:sha256" {
+			derivation.Sha256Gitoid = identity
+			adgs.Sha256[identity] = body
+		} else {
+			derivation.Sha1Gitoid = identity
+			adgs.Sha1[identity] = body
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+	if len(algorithms) == 0 {
+		return fmt.Errorf("output %s has no gitoid", output)
+	}
+
+	element.Derivation = derivation
+	factory.derivations[output] = adgs
+	feature := factory.envelope.Header.Features["derivation"]
+	feature.Algorithms = appendMissing(feature.Algorithms, algorithms...)
+	sort.Strings(feature.Algorithms)
+	factory.envelope.Header.Features["derivation"] = feature
+	return nil
+}
+
+// derivationReferrer returns an output whose derivation references the ADG
+// of the derivation of output as a bom, if there is one.
+func (factory *factoryImpl) derivationReferrer(output string) string {
+	derivation := factory.envelope.Mapping[output].Derivation
+	if derivation == nil {
+		return ""
+	}
+	var boms []string
+	for _, gitoid := range []string{derivation.Sha1Gitoid, derivation.Sha256Gitoid} {
+		if gitoid != "" {
+			boms = append(boms, " bom "+gitoid+"\n")
+		}
+	}
+	var referrers []string
+	for path, adgs := range factory.derivations {
+		if path == output {
+			continue
+		}
+		for _, bodies := range []map[string]string{adgs.Sha1, adgs.Sha256} {
+			for _, body := range bodies {
+				for _, bom := range boms {
+					if strings.Contains(body, bom) {
+						referrers = append(referrers, path)
+					}
+				}
+			}
+		}
+	}
+	if len(referrers) == 0 {
+		return ""
+	}
+	sort.Strings(referrers)
+	return referrers[0]
+}
+
+// inputManifest returns the gitoid of the ADG describing an input, if it has
+// one. The gitoid of a directory is the gitoid of its ADG.
+func inputManifest(e *Element, algorithm string) string {
+	if e.Type == "directory" {
+		return elementGitoid(e, algorithm)
+	}
+	if e.Derivation == nil {
+		return ""
+	}
+	if algorithm == "gitoid:sha256" {
+		return e.Derivation.Sha256Gitoid
+	}
+	return e.Derivation.Sha1Gitoid
+}
+
+// derivationADG renders gitoid -> bom references as an ADG body. The
+// omnibor library cannot attach a bom to an existing reference, so the
+// body is built here in the same format.
+func derivationADG(references map[string]string) string {
+	gitoids := make([]string, 0, len(references))
+	for gitoid := range references {
+		gitoids = append(gitoids, gitoid)
+	}
+	sort.Strings(gitoids)
+
+	var b strings.Builder
+	for _, gitoid := range gitoids {
+		if bom := references[gitoid]; bom != "" {
+			fmt.Fprintf(&b, "blob %s bom %s\n", gitoid, bom)
+		} else {
+			fmt.Fprintf(&b, "blob %s\n", gitoid)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,107 @@
+package omnitrail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddDerivation(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"src", "vendor", "out"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, sub), 0755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "util.go"), []byte("package main // util\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vendor", "lib.go"), []byte("package lib\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "out", "app"), []byte("binary"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "out", "app.tar"), []byte("archive"), 0644))
+
+	trail := NewTrail()
+	require.NoError(t, trail.Add(dir))
+	mapping := trail.Envelope().Mapping
+	path := func(rel string) string {
+		p, err := filepath.Abs(filepath.Join(dir, rel))
+		require.NoError(t, err)
+		return p
+	}
+	directoryADGs := len(trail.Sha256ADGs())
+
+	require.NoError(t, trail.AddDerivation(path("out/app"), []string{path("src/util.go"), path("src/main.go"), path("vendor"), path("src/main.go")}))
+	app := mapping[path("out/app")]
+	require.NotNil(t, app.Derivation)
+	assert.Equal(t, []string{"../src/main.go", "../src/util.go", "../vendor"}, app.Derivation.Inputs)
+	assert.Equal(t, []string{"gitoid:sha1", "gitoid:sha256"}, trail.Envelope().Header.Features["derivation"].Algorithms)
+
+	expected := derivationADG(map[string]string{
+		mapping[path("src/main.go")].Sha256Gitoid: "",
+		mapping[path("src/util.go")].Sha256Gitoid: "",
+		mapping[path("vendor")].Sha256Gitoid:      "",
+	})
+	assert.Equal(t, expected, trail.Sha256ADGs()[app.Derivation.Sha256Gitoid])
+	assert.Equal(t, adgIdentity(expected, true), app.Derivation.Sha256Gitoid)
+	assert.Len(t, trail.Sha256ADGs(), directoryADGs+1)
+
+	// the archive references the ADG of the app and of the vendored directory
+	require.NoError(t, trail.AddDerivation(path("out/app.tar"), []string{path("out/app"), path("vendor")}, WithInputManifests()))
+	tar := mapping[path("out/app.tar")]
+	assert.Equal(t, []string{"../vendor", "app"}, tar.Derivation.Inputs)
+	assert.Equal(t, derivationADG(map[string]string{
+		app.Sha1Gitoid:                     app.Derivation.Sha1Gitoid,
+		mapping[path("vendor")].Sha1Gitoid: mapping[path("vendor")].Sha1Gitoid,
+	}), trail.Sha1ADGs()[tar.Derivation.Sha1Gitoid])
+
+	// the ADG of the app is referenced by the archive, so it is kept
+	previous := app.Derivation.Sha256Gitoid
+	assert.Error(t, trail.AddDerivation(path("out/app"), []string{path("src/main.go")}))
+	assert.Contains(t, trail.Sha256ADGs(), previous)
+	assert.Equal(t, []string{"../src/main.go", "../src/util.go", "../vendor"}, app.Derivation.Inputs)
+
+	// deriving again replaces the previous ADG of an unreferenced output
+	previous = tar.Derivation.Sha256Gitoid
+	require.NoError(t, trail.AddDerivation(path("out/app.tar"), []string{path("out/app")}))
+	assert.NotContains(t, trail.Sha256ADGs(), previous)
+	assert.Equal(t, []string{"app"}, tar.Derivation.Inputs)
+
+	parsed, err := ParseADGString(FormatADGString(trail))
+	require.NoError(t, err)
+	assert.Contains(t, parsed.Sha1, tar.Derivation.Sha1Gitoid)
+	assert.Contains(t, parsed.Sha1, app.Derivation.Sha1Gitoid)
+
+	assert.Error(t, trail.AddDerivation(path("out/missing"), []string{path("src/main.go")}))
+	assert.Error(t, trail.AddDerivation(path("out/app.tar"), []string{path("src/missing.go")}))
+	assert.Error(t, trail.AddDerivation(path("out/app.tar"), []string{path("out/app.tar")}))
+	assert.Error(t, trail.AddDerivation(path("src"), []string{path("src/main.go")}))
+	assert.Error(t, trail.AddDerivation(path("out/app.tar"), nil))
+}
+
+// TestDerivationSharedContent derives from two inputs of the same content, of
+// which only one has an ADG of its own.
+func TestDerivationSharedContent(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app"), []byte("binary"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a-copy"), []byte("binary"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.tar"), []byte("archive"), 0644))
+
+	trail := NewTrail()
+	require.NoError(t, trail.Add(dir))
+	mapping := trail.Envelope().Mapping
+	path := func(rel string) string {
+		p, err := filepath.Abs(filepath.Join(dir, rel))
+		require.NoError(t, err)
+		return p
+	}
+	require.NoError(t, trail.AddDerivation(path("app"), []string{path("main.go")}))
+	app := mapping[path("app")]
+	require.Equal(t, app.Sha1Gitoid, mapping[path("a-copy")].Sha1Gitoid)
+
+	expected := derivationADG(map[string]string{app.Sha1Gitoid: app.Derivation.Sha1Gitoid})
+	for i := 0; i < 20; i++ {
+		require.NoError(t, trail.AddDerivation(path("app.tar"), []string{path("a-copy"), path("app")}, WithInputManifests()))
+		assert.Equal(t, expected, trail.Sha1ADGs()[mapping[path("app.tar")].Derivation.Sha1Gitoid])
+	}
+}
@@ -0,0 +1,227 @@
+package omnitrail
+
+import (
+	"reflect"
+	"sort"
+)
+
+// ChangeKind classifies how an entry differs between two envelopes.
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeContent  ChangeKind = "content-changed"
+	ChangeType     ChangeKind = "type-changed"
+	ChangeMetadata ChangeKind = "metadata-changed"
+	ChangeRenamed  ChangeKind = "renamed"
+	ChangeCopied   ChangeKind = "copied"
+	ChangeMoved    ChangeKind = "moved"
+)
+
+// Change describes one entry that differs between two envelopes. Path is
+// relative to the root of the envelope it was found in, using forward
+// slashes. From is the previous path of renamed, moved and copied entries.
+// Features lists the differing fields of the entry per feature.
+type Change struct {
+	Path     string              `json:"path"`
+	From     string              `json:"from,omitempty"`
+	Kind     ChangeKind          `json:"kind"`
+	Features map[string][]string `json:"features,omitempty"`
+	Old      *Element            `json:"old,omitempty"`
+	New      *Element            `json:"new,omitempty"`
+
+	// moved holds the path suffixes of the entries moved along with a directory
+	moved []string
+}
+
+// EnvelopeDiff is the structured difference between two envelopes.
+type EnvelopeDiff struct {
+	OldRoot  string   `json:"old_root"`
+	NewRoot  string   `json:"new_root"`
+	Features []string `json:"features,omitempty"`
+	Changes  []Change `json:"changes"`
+
+	old *Envelope
+	new *Envelope
+}
+
+type DiffOption func(o *DiffOptions)
+
+type DiffOptions struct {
+	// Exhaustive disables skipping of subtrees whose directory gitoids and
+	// names are identical. Directory gitoids only cover content, so metadata
+	// changes inside identical subtrees are only reported in exhaustive mode.
+	Exhaustive bool
+}
+
+func WithExhaustiveDiff() DiffOption {
+	return func(o *DiffOptions) {
+		o.Exhaustive = true
+	}
+}
+
+// Diff compares two envelopes. Entries are matched by their path relative to
+// the root of each envelope, so trails of the same tree taken at different
+// locations can be compared. Envelopes without a single root are compared by
+// their original keys. Removed and added entries with identical content are
+// reported as renames, moves of whole directories or copies.
+func Diff(oldEnvelope, newEnvelope *Envelope, option ...DiffOption) *EnvelopeDiff {
+	o := &DiffOptions{}
+	for _, opt := range option {
+		opt(o)
+	}
+
+	d := &EnvelopeDiff{
+		OldRoot: envelopeRoot(oldEnvelope.Mapping),
+		NewRoot: envelopeRoot(newEnvelope.Mapping),
+		Changes: []Change{},
+		old:     oldEnvelope,
+		new:     newEnvelope,
+	}
+	d.Features = diffFeatures(oldEnvelope.Header, newEnvelope.Header)
+
+	oldMapping := relativeMapping(oldEnvelope.Mapping, d.OldRoot)
+	newMapping := relativeMapping(newEnvelope.Mapping, d.NewRoot)
+
+	union := make(map[string]*Element, len(oldMapping))
+	for k, v := range oldMapping {
+		union[k] = v
+	}
+	for k, v := range newMapping {
+		union[k] = v
+	}
+
+	var oldLayout, newLayout map[string]string
+	if !o.Exhaustive {
+		oldLayout = layoutDigests(oldMapping)
+		newLayout = layoutDigests(newMapping)
+	}
+
+	// identical directories, their descendants are not compared
+	skipped := make(map[string]bool)
+	for _, path := range sortedKeys(union) {
+		if !o.Exhaustive && isSkipped(path, skipped) {
+			continue
+		}
+		oldElement, newElement := oldMapping[path], newMapping[path]
+		if oldElement == nil {
+			d.Changes = append(d.Changes, Change{Path: path, Kind: ChangeAdded, New: newElement})
+			continue
+		}
+		if newElement == nil {
+			d.Changes = append(d.Changes, Change{Path: path, Kind: ChangeRemoved, Old: oldElement})
+			continue
+		}
+		if !o.Exhaustive && oldElement.Type == "directory" && newElement.Type == "directory" && sameContent(oldElement, newElement) && oldLayout[path] == newLayout[path] {
+			skipped[path] = true
+		}
+		if change, ok := compareElements(path, oldElement, newElement); ok {
+			d.Changes = append(d.Changes, change)
+		}
+	}
+	d.detectMoves(oldMapping, newMapping)
+
+	return d
+}
+
+func isSkipped(path string, skipped map[string]bool) bool {
+	for parent, ok := parentPath(path); ok; parent, ok = parentPath(parent) {
+		if skipped[parent] {
+			return true
+		}
+	}
+	return false
+}
+
+// compareElements classifies the difference between two elements stored at
+// the same path. It returns false if the elements are equal.
+func compareElements(path string, oldElement, newElement *Element) (Change, bool) {
+	change := Change{
+		Path:     path,
+		Features: make(map[string][]string),
+		Old:      oldElement,
+		New:      newElement,
+	}
+	if oldElement.Type != newElement.Type {
+		change.Kind = ChangeType
+	}
+
+	oldFields := flattenElement(oldElement)
+	newFields := flattenElement(newElement)
+	contentChanged := false
+	for _, field := range unionFields(oldFields, newFields) {
+		if field == "type" {
+			continue
+		}
+		oldValue, oldOk := oldFields[field]
+		newValue, newOk := newFields[field]
+		if oldOk && newOk && oldValue == newValue {
+			continue
+		}
+		key := topLevelKey(field)
+		feature := elementFeature(key, newElement)
+		change.Features[feature] = append(change.Features[feature], field)
+		// a digest that is only present on one side means the trails were
+		// taken with different algorithms, not that the content changed
+		if oldOk && newOk && !metadataFeatures[feature] {
+			contentChanged = true
+		}
+	}
+
+	if change.Kind == "" {
+		switch {
+		case contentChanged:
+			change.Kind = ChangeContent
+		case len(change.Features) > 0:
+			change.Kind = ChangeMetadata
+		default:
+			return Change{}, false
+		}
+	}
+	if len(change.Features) == 0 {
+		change.Features = nil
+	}
+	return change, true
+}
+
+func unionFields(a, b map[string]string) []string {
+	seen := make(map[string]bool, len(a))
+	fields := make([]string, 0, len(a))
+	for _, m := range []map[string]string{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				fields = append(fields, k)
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func topLevelKey(field string) string {
+	for i := 0; i < len(field); i++ {
+		if field[i] == '.' {
+			return field[:i]
+		}
+	}
+	return field
+}
+
+func diffFeatures(oldHeader, newHeader Header) []string {
+	var names []string
+	for name, feature := range oldHeader.Features {
+		other, ok := newHeader.Features[name]
+		if !ok || !reflect.DeepEqual(feature, other) {
+			names = append(names, name)
+		}
+	}
+	for name := range newHeader.Features {
+		if _, ok := oldHeader.Features[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
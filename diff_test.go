@@ -0,0 +1,217 @@
+package omnitrail
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadEnvelope(t *testing.T, name string) *Envelope {
+	b, err := os.ReadFile("./test/" + name + ".json")
+	require.NoError(t, err)
+	var envelope Envelope
+	require.NoError(t, json.Unmarshal(b, &envelope))
+	return &envelope
+}
+
+// rebase moves every key of the envelope under a new root
+func rebase(envelope *Envelope, root string) *Envelope {
+	old := envelopeRoot(envelope.Mapping)
+	mapping := make(map[string]*Element)
+	for k, v := range envelope.Mapping {
+		mapping[root+strings.TrimPrefix(k, old)] = v.clone()
+	}
+	return &Envelope{Header: envelope.Header, Mapping: mapping}
+}
+
+func TestDiffIdentical(t *testing.T) {
+	a := loadEnvelope(t, "deep")
+	b := rebase(a, "/build/2/deep")
+	d := Diff(a, b)
+	assert.Empty(t, d.Changes)
+	assert.Empty(t, d.Features)
+	ops, err := d.JSONPatch()
+	require.NoError(t, err)
+	assert.Empty(t, ops)
+	assert.Equal(t, "/build/2/deep", d.NewRoot)
+}
+
+func TestDiffChanges(t *testing.T) {
+	a := rebase(loadEnvelope(t, "deep"), "/a")
+	b := rebase(a, "/b")
+
+	b.Mapping["/b/root.txt"].Sha256 = "00"
+	b.Mapping["/b/root.txt"].Posix.Permissions = "-rwxr-xr-x"
+	b.Mapping["/b/dir1/file1.txt"].Posix.Permissions = "-rwxr-xr-x"
+	b.Mapping["/b/new.txt"] = a.Mapping["/a/root.txt"].clone()
+	delete(b.Mapping, "/b/dir1/dir2/file2.txt")
+	b.Mapping["/b/dir1/dir2"].Type = "file"
+
+	// directory gitoids are not recomputed here, so compare every entry
+	d := Diff(a, b, WithExhaustiveDiff())
+	kinds := make(map[string]ChangeKind)
+	for _, c := range d.Changes {
+		kinds[c.Path] = c.Kind
+	}
+	assert.Equal(t, map[string]ChangeKind{
+		"dir1/dir2":           ChangeType,
+		"dir1/dir2/file2.txt": ChangeRemoved,
+		"dir1/file1.txt":      ChangeMetadata,
+		"new.txt":             ChangeCopied,
+		"root.txt":            ChangeContent,
+	}, kinds)
+
+	for _, c := range d.Changes {
+		if c.Path == "root.txt" {
+			assert.Equal(t, map[string][]string{
+				"file":  {"sha256"},
+				"posix": {"posix.permissions"},
+			}, c.Features)
+		}
+	}
+
+	ops, err := d.JSONPatch()
+	require.NoError(t, err)
+	assert.Contains(t, ops, PatchOperation{Op: "remove", Path: "/mapping/~1a~1dir1~1dir2~1file2.txt"})
+	assert.Contains(t, ops, PatchOperation{Op: "replace", Path: "/mapping/~1a~1root.txt/sha256", Value: "00"})
+	assert.Contains(t, ops, PatchOperation{Op: "replace", Path: "/mapping/~1a~1root.txt/posix/permissions", Value: "-rwxr-xr-x"})
+}
+
+func TestDiffSkipsIdenticalSubtrees(t *testing.T) {
+	a := rebase(loadEnvelope(t, "deep"), "/a")
+	b := rebase(a, "/b")
+	b.Mapping["/b/dir1/dir2/file2.txt"].Posix.Permissions = "-rwxr-xr-x"
+
+	assert.Empty(t, Diff(a, b).Changes)
+
+	d := Diff(a, b, WithExhaustiveDiff())
+	if assert.Len(t, d.Changes, 1) {
+		assert.Equal(t, "dir1/dir2/file2.txt", d.Changes[0].Path)
+		assert.Equal(t, ChangeMetadata, d.Changes[0].Kind)
+	}
+}
+
+func TestDiffFeatures(t *testing.T) {
+	a := loadEnvelope(t, "one-file")
+	b := rebase(a, "/b")
+	b.Header.Features = map[string]Feature{"file": {Algorithms: []string{"sha256"}}}
+	for _, e := range b.Mapping {
+		e.Sha1 = ""
+	}
+
+	d := Diff(a, b, WithExhaustiveDiff())
+	assert.Equal(t, []string{"directory", "file", "posix"}, d.Features)
+	if assert.Len(t, d.Changes, 1) {
+		// a digest missing on one side is not a content change
+		assert.Equal(t, ChangeMetadata, d.Changes[0].Kind)
+	}
+	ops, err := d.JSONPatch()
+	require.NoError(t, err)
+	assert.Contains(t, ops, PatchOperation{Op: "remove", Path: "/header/features/posix"})
+}
+
+func TestDiffRenamesAndMoves(t *testing.T) {
+	a := rebase(loadEnvelope(t, "deep"), "/a")
+	b := rebase(a, "/b")
+
+	// dir1 moves to lib, root.txt is renamed and copied
+	for k, v := range b.Mapping {
+		if strings.HasPrefix(k, "/b/dir1") {
+			delete(b.Mapping, k)
+			b.Mapping["/b/lib"+strings.TrimPrefix(k, "/b/dir1")] = v
+		}
+	}
+	b.Mapping["/b/lib/dir2/file2.txt"].Posix.Permissions = "-rwxr-xr-x"
+	b.Mapping["/b/docs/root.txt"] = b.Mapping["/b/root.txt"]
+	b.Mapping["/b/copy.txt"] = b.Mapping["/b/root.txt"].clone()
+	delete(b.Mapping, "/b/root.txt")
+
+	d := Diff(a, b)
+	type move struct {
+		kind ChangeKind
+		from string
+	}
+	changes := make(map[string]move)
+	for _, c := range d.Changes {
+		changes[c.Path] = move{c.Kind, c.From}
+	}
+	assert.Equal(t, map[string]move{
+		"copy.txt":           {ChangeCopied, "root.txt"},
+		"lib":                {ChangeMoved, "dir1"},
+		"lib/dir2/file2.txt": {ChangeMetadata, "dir1/dir2/file2.txt"},
+		"docs/root.txt":      {ChangeRenamed, "root.txt"},
+	}, changes)
+
+	// a directory with the same content but other names is not a move
+	c := rebase(a, "/c")
+	for k, v := range c.Mapping {
+		if strings.HasPrefix(k, "/c/dir1") {
+			delete(c.Mapping, k)
+			c.Mapping["/c/lib"+strings.TrimPrefix(k, "/c/dir1")] = v
+		}
+	}
+	c.Mapping["/c/lib/other.txt"] = c.Mapping["/c/lib/file1.txt"]
+	delete(c.Mapping, "/c/lib/file1.txt")
+	kinds := make(map[string]ChangeKind)
+	for _, change := range Diff(a, c).Changes {
+		kinds[change.Path] = change.Kind
+	}
+	assert.Equal(t, map[string]ChangeKind{
+		"dir1":          ChangeRemoved,
+		"lib":           ChangeAdded,
+		"lib/dir2":      ChangeMoved,
+		"lib/other.txt": ChangeRenamed,
+	}, kinds)
+
+	// applying the patch to the old envelope yields the new one
+	expected := mustJSONValue(t, rebase(b, "/a"))
+	actual := mustJSONValue(t, a)
+	ops, err := d.JSONPatch()
+	require.NoError(t, err)
+	for _, op := range ops {
+		applyPatchOperation(t, actual, op)
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func mustJSONValue(t *testing.T, v interface{}) interface{} {
+	res, err := jsonValue(v)
+	require.NoError(t, err)
+	return res
+}
+
+// applyPatchOperation applies a JSON Patch operation to a document made of
+// objects only, which is sufficient for envelopes.
+func applyPatchOperation(t *testing.T, doc interface{}, op PatchOperation) {
+	resolve := func(pointer string) (map[string]interface{}, string) {
+		parts := strings.Split(pointer, "/")[1:]
+		current := doc.(map[string]interface{})
+		for _, part := range parts[:len(parts)-1] {
+			part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+			current = current[part].(map[string]interface{})
+		}
+		last := parts[len(parts)-1]
+		return current, strings.ReplaceAll(strings.ReplaceAll(last, "~1", "/"), "~0", "~")
+	}
+	parent, key := resolve(op.Path)
+	switch op.Op {
+	case "add", "replace":
+		parent[key] = mustJSONValue(t, op.Value)
+	case "remove":
+		require.Contains(t, parent, key)
+		delete(parent, key)
+	case "move", "copy":
+		fromParent, fromKey := resolve(op.From)
+		require.Contains(t, fromParent, fromKey)
+		parent[key] = mustJSONValue(t, fromParent[fromKey])
+		if op.Op == "move" {
+			delete(fromParent, fromKey)
+		}
+	default:
+		t.Fatalf("unexpected operation %s", op.Op)
+	}
+}
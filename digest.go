@@ -0,0 +1,100 @@
+package omnitrail
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"sync"
+
+	"github.com/pjbgf/sha1cd"
+	"golang.org/x/crypto/sha3"
+)
+
+// ErrSHA1Collision is returned for files that carry a SHA-1 collision attack,
+// such as the SHAttered PDFs. SHA-1 based digests are computed with the
+// counter-cryptanalysis git uses, which detects such files while hashing.
+var ErrSHA1Collision = errors.New("sha1 collision attack detected")
+
+// DigestAlgorithm describes how the file plugin computes a digest. New is
+// given the size of the file, so that algorithms such as gitoids can hash a
+// header before the content. Encode defaults to lower case hex. Files are
+// rejected with ErrSHA1Collision if the hash implements
+// sha1cd.CollisionResistantHash and reports a collision.
+type DigestAlgorithm struct {
+	Name   string
+	New    func(size int64) hash.Hash
+	Encode func(sum []byte) string
+}
+
+func (a DigestAlgorithm) encode(sum []byte) string {
+	if a.Encode == nil {
+		return hex.EncodeToString(sum)
+	}
+	return a.Encode(sum)
+}
+
+var (
+	digestLock     sync.RWMutex
+	digestRegistry = make(map[string]DigestAlgorithm)
+)
+
+// RegisterDigest makes a digest algorithm available to WithFileAlgorithms.
+// Registering a name again replaces the previous algorithm.
+func RegisterDigest(algorithm DigestAlgorithm) {
+	digestLock.Lock()
+	defer digestLock.Unlock()
+	digestRegistry[algorithm.Name] = algorithm
+}
+
+func LookupDigest(name string) (DigestAlgorithm, bool) {
+	digestLock.RLock()
+	defer digestLock.RUnlock()
+	algorithm, ok := digestRegistry[name]
+	return algorithm, ok
+}
+
+// Digests returns the names of all registered digest algorithms.
+func Digests() []string {
+	digestLock.RLock()
+	defer digestLock.RUnlock()
+	names := make([]string, 0, len(digestRegistry))
+	for name := range digestRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func plainDigest(newHash func() hash.Hash) func(int64) hash.Hash {
+	return func(int64) hash.Hash {
+		return newHash()
+	}
+}
+
+// gitoidDigest hashes content as a git blob object.
+func gitoidDigest(newHash func() hash.Hash) func(int64) hash.Hash {
+	return func(size int64) hash.Hash {
+		h := newHash()
+		fmt.Fprintf(h, "blob %d\x00", size)
+		return h
+	}
+}
+
+func init() {
+	for _, algorithm := range []DigestAlgorithm{
+		{Name: "sha1", New: plainDigest(sha1cd.New)},
+		{Name: "sha256", New: plainDigest(sha256.New)},
+		{Name: "sha384", New: plainDigest(sha512.New384)},
+		{Name: "sha512", New: plainDigest(sha512.New)},
+		{Name: "sha512/256", New: plainDigest(sha512.New512_256)},
+		{Name: "sha3-256", New: plainDigest(sha3.New256)},
+		{Name: "gitoid:sha1", New: gitoidDigest(sha1cd.New)},
+		{Name: "gitoid:sha256", New: gitoidDigest(sha256.New)},
+	} {
+		RegisterDigest(algorithm)
+	}
+}
@@ -0,0 +1,106 @@
+package omnitrail
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hello")
+	require.NoError(t, os.WriteFile(path, []byte("hello world\n"), 0644))
+
+	trail := NewTrail(WithFileAlgorithms("sha384", "sha512", "sha512/256", "sha3-256"))
+	require.NoError(t, trail.Add(dir))
+	envelope := trail.Envelope()
+	assert.Equal(t, []string{"gitoid:sha1", "gitoid:sha256", "sha1", "sha256", "sha3-256", "sha384", "sha512", "sha512/256"},
+		envelope.Header.Features["file"].Algorithms)
+
+	e := envelope.Mapping[path]
+	// git hash-object
+	assert.Equal(t, "3b18e512dba79e4c8300dd08aeb37f8e728b8dad", e.Sha1Gitoid)
+	assert.Equal(t, map[string]string{
+		"sha384":     "6b3b69ff0a404f28d75e98a066d3fc64fffd9940870cc68bece28545b9a75086b343d7a1366838083e4b8f3ca6fd3c80",
+		"sha512":     "db3974a97f2407b7cae1ae637c0030687a11913274d578492558e39c16c017de84eacdc8c62fe34ee4e12b4b1428817f09b6a2760c3f8a664ceae94d2434a593",
+		"sha512/256": "bf73ee1fb7e8bf8fcdd5da06dd547052cd0f929a88f4aead68b218d3bde91134",
+		"sha3-256":   "a8009a7a528d87778c356da3a55d964719e818666a04e4f960c9e2439e35f138",
+	}, e.Digests)
+
+	b, err := json.Marshal(e)
+	require.NoError(t, err)
+	m := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal(b, &m))
+	assert.Equal(t, e.Digests["sha384"], m["sha384"])
+	assert.Equal(t, e.Sha256, m["sha256"])
+
+	var decoded Element
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, *e, decoded)
+}
+
+func TestRegisterDigest(t *testing.T) {
+	RegisterDigest(DigestAlgorithm{
+		Name: "test:md5",
+		New:  plainDigest(md5.New),
+		Encode: func(sum []byte) string {
+			return base64.StdEncoding.EncodeToString(sum)
+		},
+	})
+	defer func() {
+		digestLock.Lock()
+		delete(digestRegistry, "test:md5")
+		digestLock.Unlock()
+	}()
+	assert.Contains(t, Digests(), "test:md5")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hello")
+	require.NoError(t, os.WriteFile(path, []byte("hello world\n"), 0644))
+	trail := NewTrail(WithFileAlgorithms("test:md5"))
+	require.NoError(t, trail.Add(dir))
+	assert.Equal(t, "b1kCrCNwJL3QwXbLkwY9xA==", trail.Envelope().Mapping[path].Digests["test:md5"])
+
+	trail = NewTrail(WithFileAlgorithms("unknown"))
+	assert.Error(t, trail.Add(dir))
+}
+
+// shatteredFile copies one of the public SHAttered PDFs, which sha1cd ships
+// as test data, from the module cache.
+func shatteredFile(t *testing.T, dir, name string) string {
+	out, err := exec.Command("go", "env", "GOMODCACHE").Output()
+	if err != nil {
+		t.Skipf("go env: %v", err)
+	}
+	src := filepath.Join(strings.TrimSpace(string(out)), "github.com", "pjbgf", "sha1cd@v0.3.0", "test", "testdata", "files", name)
+	b, err := os.ReadFile(src)
+	if err != nil {
+		t.Skipf("%s is not in the module cache", name)
+	}
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, b, 0644))
+	return path
+}
+
+func TestSHA1Collision(t *testing.T) {
+	for _, name := range []string{"shattered-1.pdf", "shattered-2.pdf"} {
+		dir := t.TempDir()
+		shatteredFile(t, dir, name)
+		err := NewTrail().Add(dir)
+		assert.ErrorIs(t, err, ErrSHA1Collision, name)
+	}
+
+	dir := t.TempDir()
+	path := shatteredFile(t, dir, "valid-file.txt")
+	trail := NewTrail()
+	require.NoError(t, trail.Add(dir))
+	assert.Equal(t, "2b915da50f163514d390c9d87a4f3e23eb663f8a", trail.Envelope().Mapping[path].Sha1)
+}
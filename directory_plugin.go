@@ -16,7 +16,31 @@ type DirectoryPlugin struct {
 	directories map[string]bool
 	sha1adgs    map[string]omnibor.ArtifactTree
 	sha256adgs  map[string]omnibor.ArtifactTree
-	AllowList   []string
+	// lstat of every path, only recorded for git trees
+	gitEntries map[string]gitEntry
+	AllowList  []string
+}
+
+func (plug *DirectoryPlugin) Configure(o *Options) {
+	plug.algorithms = appendMissing(plug.algorithms, o.DirectoryAlgorithms...)
+	if policy, ok := LookupPolicy(o.Policy); ok {
+		plug.algorithms = policy.filter(plug.algorithms)
+	}
+	sort.Strings(plug.algorithms)
+}
+
+// Algorithms returns the directory algorithms enabled by Configure.
+func (plug *DirectoryPlugin) Algorithms() []string {
+	return plug.algorithms
+}
+
+func (plug *DirectoryPlugin) gitTreesEnabled() bool {
+	for _, algorithm := range plug.algorithms {
+		if strings.HasPrefix(algorithm, "gittree:") {
+			return true
+		}
+	}
+	return false
 }
 
 func (plug *DirectoryPlugin) isAllowedDirectory(path string) bool {
@@ -51,6 +75,15 @@ func (plug *DirectoryPlugin) Add(path string) error {
 		}
 		return err
 	}
+	if plug.gitTreesEnabled() {
+		entry := gitEntry{mode: fileInfo.Mode()}
+		if fileInfo.Mode()&os.ModeSymlink != 0 {
+			if entry.target, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		plug.gitEntries[path] = entry
+	}
 	if fileInfo.Mode()&os.ModeSymlink != 0 {
 		// path is a symlink
 		targetPath, err := os.Readlink(path)
@@ -92,104 +125,70 @@ func (plug *DirectoryPlugin) Store(envelope *Envelope) error {
 		keys = append(keys, path)
 	}
 
-	var sha1tree map[string]omnibor.ArtifactTree
-	var sha256tree map[string]omnibor.ArtifactTree
-
 	for _, algorithm := range plug.algorithms {
+		var adgs map[string]omnibor.ArtifactTree
 		switch algorithm {
 		case "gitoid:sha1":
-			sha1tree = make(map[string]omnibor.ArtifactTree)
+			adgs = plug.sha1adgs
 		case "gitoid:sha256":
-			sha256tree = make(map[string]omnibor.ArtifactTree)
-		default:
+			adgs = plug.sha256adgs
+		case "nar:sha256":
+			var roots []string
+			for _, path := range keys {
+				if !plug.directories[filepath.Dir(path)] || filepath.Dir(path) == path {
+					roots = append(roots, path)
+				}
+			}
+			hashes, err := narHashes(roots)
+			if err != nil {
+				return err
+			}
+			for key, hash := range hashes {
+				if element, ok := envelope.Mapping[key]; ok {
+					element.NarSha256 = hash
+				}
+			}
 			continue
-		}
-	}
-
-	for _, key := range keys {
-		if sha1tree != nil {
-			sha1tree[key] = omnibor.NewSha1OmniBOR()
-		}
-		if sha256tree != nil {
-			sha256tree[key] = omnibor.NewSha256OmniBOR()
-		}
-	}
-
-	for path, element := range envelope.Mapping {
-		dir := filepath.Dir(path)
-		if _, ok := sha1tree[dir]; ok {
-			err := sha1tree[dir].AddExistingReference(element.Sha1Gitoid)
+		case "h1":
+			hashes, err := moduleHashes(envelope, keys)
 			if err != nil {
 				return err
 			}
-			err = sha256tree[dir].AddExistingReference(element.Sha256Gitoid)
+			for key, hash := range hashes {
+				if element, ok := envelope.Mapping[key]; ok {
+					element.H1 = hash
+				}
+			}
+			continue
+		default:
+			trees, err := buildGitTrees(envelope.Mapping, plug.gitEntries, algorithm, false)
 			if err != nil {
 				return err
 			}
+			for key, tree := range trees {
+				if element, ok := envelope.Mapping[key]; ok {
+					setElementGitTree(element, algorithm, tree)
+				}
+			}
+			continue
 		}
-	}
-
-	// sort the keys from the longest length to the shortest length
-	sort.Slice(keys, func(i, j int) bool {
-		return len(keys[i]) > len(keys[j])
-	})
 
-	if sha1tree != nil {
-		err := plug.addKeysToTree(keys, sha1tree)
+		tree, err := buildADGs(envelope.Mapping, keys, algorithm)
 		if err != nil {
 			return err
 		}
-	}
-	if sha256tree != nil {
-		err := plug.addKeysToTree(keys, sha256tree)
-		if err != nil {
-			return err
-		}
-	}
 
-	for key, value := range sha1tree {
-		if _, ok := envelope.Mapping[key]; !ok {
-			envelope.Mapping[key] = &Element{
-				Type: "directory",
+		for key, value := range tree {
+			if _, ok := envelope.Mapping[key]; !ok {
+				envelope.Mapping[key] = &Element{
+					Type: "directory",
+				}
 			}
+			setElementGitoid(envelope.Mapping[key], algorithm, value.Identity())
+			adgs[key] = value
 		}
-		e := envelope.Mapping[key]
-		e.Sha1Gitoid = value.Identity()
-		envelope.Mapping[key] = e
 	}
 
-	for key, value := range sha256tree {
-		if _, ok := envelope.Mapping[key]; !ok {
-			envelope.Mapping[key] = &Element{
-				Type: "directory",
-			}
-		}
-		e := envelope.Mapping[key]
-		e.Sha256Gitoid = value.Identity()
-		envelope.Mapping[key] = e
-	}
-
-	for k, v := range sha1tree {
-		plug.sha1adgs[k] = v
-	}
-
-	for k, v := range sha256tree {
-		plug.sha256adgs[k] = v
-	}
-
-	return nil
-}
-
-func (plug *DirectoryPlugin) addKeysToTree(keys []string, tree map[string]omnibor.ArtifactTree) error {
-	for _, key := range keys {
-		dir := filepath.Dir(key)
-		if _, ok := tree[dir]; ok {
-			err := tree[dir].AddExistingReference(tree[key].Identity())
-			if err != nil {
-				return err
-			}
-		}
-	}
 	return nil
 }
 
@@ -205,5 +204,6 @@ func NewDirectoryPlugin() Plugin {
 		directories: make(map[string]bool),
 		sha1adgs:    make(map[string]omnibor.ArtifactTree),
 		sha256adgs:  make(map[string]omnibor.ArtifactTree),
+		gitEntries:  make(map[string]gitEntry),
 	}
 }
@@ -0,0 +1,202 @@
+package omnitrail
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DuplicateReport groups the files of an envelope that share their content or
+// their inode.
+type DuplicateReport struct {
+	Duplicates  []DuplicateGroup `json:"duplicates"`
+	Hardlinks   []HardlinkGroup  `json:"hardlinks"`
+	WastedBytes int64            `json:"wasted_bytes"`
+}
+
+// DuplicateGroup is a set of paths with identical content. Copies is the
+// number of distinct inodes holding the content; every copy after the first
+// one is counted as wasted.
+type DuplicateGroup struct {
+	Digest      string          `json:"digest"`
+	Size        int64           `json:"size"`
+	Copies      int             `json:"copies"`
+	WastedBytes int64           `json:"wasted_bytes"`
+	Paths       []DuplicatePath `json:"paths"`
+}
+
+type DuplicatePath struct {
+	Path string `json:"path"`
+	// Hardlink is set if the path shares its inode with another path of the
+	// group, rather than holding its own copy of the content
+	Hardlink bool `json:"hardlink,omitempty"`
+}
+
+// HardlinkGroup is a set of paths that are hardlinks of the same inode.
+type HardlinkGroup struct {
+	Device string   `json:"device"`
+	Inode  string   `json:"inode"`
+	Paths  []string `json:"paths"`
+}
+
+// FindDuplicates groups the files of an envelope by content digest and by
+// device and inode. Inodes are taken from the posix feature, or read from
+// the files on disk when it is not recorded; files that no longer exist, or
+// platforms without inodes, count every path as its own copy.
+func FindDuplicates(envelope *Envelope) *DuplicateReport {
+	report := &DuplicateReport{
+		Duplicates: []DuplicateGroup{},
+		Hardlinks:  []HardlinkGroup{},
+	}
+
+	byContent := make(map[string][]string)
+	byInode := make(map[string][]string)
+	links := make(map[string]fileLinks)
+	for _, path := range sortedKeys(envelope.Mapping) {
+		element := envelope.Mapping[path]
+		if element.Type != "file" {
+			continue
+		}
+		if key := contentKey(element); key != "" {
+			byContent[key] = append(byContent[key], path)
+		}
+		if l, ok := linksOf(path, element); ok {
+			links[path] = l
+		}
+		if links[path].hardlinked() {
+			key := inodeKey(path, links)
+			byInode[key] = append(byInode[key], path)
+		}
+	}
+
+	for digest, paths := range byContent {
+		if len(paths) < 2 {
+			continue
+		}
+		group := DuplicateGroup{Digest: digest}
+		inodes := make(map[string]int)
+		for _, path := range paths {
+			inodes[inodeKey(path, links)]++
+		}
+		for _, path := range paths {
+			element := envelope.Mapping[path]
+			group.Paths = append(group.Paths, DuplicatePath{
+				Path:     path,
+				Hardlink: links[path].hardlinked() && inodes[inodeKey(path, links)] > 1,
+			})
+			if element.Posix != nil {
+				group.Size, _ = strconv.ParseInt(element.Posix.Size, 10, 64)
+			}
+		}
+		group.Copies = len(inodes)
+		group.WastedBytes = group.Size * int64(group.Copies-1)
+		report.WastedBytes += group.WastedBytes
+		report.Duplicates = append(report.Duplicates, group)
+	}
+	sort.Slice(report.Duplicates, func(i, j int) bool {
+		a, b := report.Duplicates[i], report.Duplicates[j]
+		if a.WastedBytes != b.WastedBytes {
+			return a.WastedBytes > b.WastedBytes
+		}
+		return a.Paths[0].Path < b.Paths[0].Path
+	})
+
+	for _, paths := range byInode {
+		if len(paths) < 2 {
+			continue
+		}
+		l := links[paths[0]]
+		report.Hardlinks = append(report.Hardlinks, HardlinkGroup{
+			Device: l.device,
+			Inode:  l.inode,
+			Paths:  paths,
+		})
+	}
+	sort.Slice(report.Hardlinks, func(i, j int) bool {
+		return report.Hardlinks[i].Paths[0] < report.Hardlinks[j].Paths[0]
+	})
+
+	return report
+}
+
+// fileLinks is the device, inode and link count of a file. A symlink is
+// described by its target, so that it does not count as a copy of it.
+type fileLinks struct {
+	device  string
+	inode   string
+	links   uint64
+	symlink bool
+}
+
+// linksOf returns the inode of a file recorded by the posix feature, or
+// reads it from disk.
+func linksOf(path string, element *Element) (fileLinks, bool) {
+	if element.Posix != nil && element.Posix.FileInode != "" {
+		links, err := strconv.ParseUint(element.Posix.HardLinkCount, 10, 64)
+		if err != nil {
+			return fileLinks{}, false
+		}
+		return fileLinks{
+			device:  element.Posix.FileDeviceID,
+			inode:   element.Posix.FileInode,
+			links:   links,
+			symlink: element.Posix.Symlink != nil,
+		}, true
+	}
+	linkInfo, err := os.Lstat(path)
+	if err != nil {
+		return fileLinks{}, false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileLinks{}, false
+	}
+	id, links, ok := fileIdentityOf(info)
+	if !ok {
+		return fileLinks{}, false
+	}
+	return fileLinks{
+		device:  strconv.FormatUint(id.device, 10),
+		inode:   strconv.FormatUint(id.inode, 10),
+		links:   links,
+		symlink: linkInfo.Mode()&os.ModeSymlink != 0,
+	}, true
+}
+
+// hardlinked reports whether the inode has more than one link. Symlinks
+// share the inode of their target, but are never hardlinks of it.
+func (l fileLinks) hardlinked() bool {
+	return !l.symlink && l.links > 1
+}
+
+func inodeKey(path string, links map[string]fileLinks) string {
+	l, ok := links[path]
+	if !ok {
+		return path
+	}
+	return l.device + ":" + l.inode
+}
+
+func (r *DuplicateReport) String() string {
+	var b strings.Builder
+	for _, group := range r.Duplicates {
+		fmt.Fprintf(&b, "%s %d bytes, %d copies, %d bytes wasted\n", group.Digest, group.Size, group.Copies, group.WastedBytes)
+		for _, path := range group.Paths {
+			if path.Hardlink {
+				fmt.Fprintf(&b, "  %s (hardlink)\n", path.Path)
+			} else {
+				fmt.Fprintf(&b, "  %s\n", path.Path)
+			}
+		}
+	}
+	for _, group := range r.Hardlinks {
+		fmt.Fprintf(&b, "inode %s on device %s\n", group.Inode, group.Device)
+		for _, path := range group.Paths {
+			fmt.Fprintf(&b, "  %s\n", path)
+		}
+	}
+	fmt.Fprintf(&b, "%d bytes wasted\n", r.WastedBytes)
+	return b.String()
+}
@@ -0,0 +1,64 @@
+package omnitrail
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"a", "b", "c"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, sub), 0755))
+	}
+	content := []byte("duplicated library")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a", "lib.so"), content, 0644))
+	require.NoError(t, os.Link(filepath.Join(dir, "a", "lib.so"), filepath.Join(dir, "b", "lib.so")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "c", "lib.so"), content, 0644))
+	// a symlink shares the inode of its hardlinked target without being a link
+	require.NoError(t, os.Symlink("../a/lib.so", filepath.Join(dir, "c", "link.so")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "unique"), []byte("unique"), 0644))
+
+	trail := NewTrail()
+	require.NoError(t, trail.Add(dir))
+	envelope := trail.Envelope()
+	if _, ok := envelope.Header.Features["posix"]; !ok {
+		t.Skip("inodes are not available on this platform")
+	}
+
+	a, _ := filepath.Abs(filepath.Join(dir, "a", "lib.so"))
+	b, _ := filepath.Abs(filepath.Join(dir, "b", "lib.so"))
+	c, _ := filepath.Abs(filepath.Join(dir, "c", "lib.so"))
+	link, _ := filepath.Abs(filepath.Join(dir, "c", "link.so"))
+	assert.Equal(t, envelope.Mapping[a].Sha256, envelope.Mapping[b].Sha256)
+
+	report := FindDuplicates(envelope)
+	require.Len(t, report.Duplicates, 1)
+	group := report.Duplicates[0]
+	assert.Equal(t, "gitoid:sha256:"+envelope.Mapping[a].Sha256Gitoid, group.Digest)
+	assert.Equal(t, int64(len(content)), group.Size)
+	assert.Equal(t, 2, group.Copies)
+	assert.Equal(t, int64(len(content)), group.WastedBytes)
+	assert.Equal(t, []DuplicatePath{
+		{Path: a, Hardlink: true},
+		{Path: b, Hardlink: true},
+		{Path: c},
+		{Path: link},
+	}, group.Paths)
+
+	require.Len(t, report.Hardlinks, 1)
+	assert.Equal(t, []string{a, b}, report.Hardlinks[0].Paths)
+	info, err := os.Stat(a)
+	require.NoError(t, err)
+	id, links, ok := fileIdentityOf(info)
+	require.True(t, ok)
+	assert.Equal(t, strconv.FormatUint(id.inode, 10), report.Hardlinks[0].Inode)
+	assert.Equal(t, uint64(2), links)
+	assert.Equal(t, envelope.Mapping[a].Posix.FileInode, report.Hardlinks[0].Inode)
+	assert.Equal(t, "2", envelope.Mapping[a].Posix.HardLinkCount)
+	assert.Contains(t, report.String(), b+" (hardlink)")
+}
@@ -0,0 +1,374 @@
+package omnitrail
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// elementFeatures maps top-level Element JSON keys to the feature that
+// produces them. Keys that are not listed are digests and belong to the
+// "file" or "directory" feature, depending on the type of the element.
+var elementFeatures = map[string]string{
+	"posix":        "posix",
+	"derivation":   "derivation",
+	"swhid":        "swhid",
+	"fsverity":     "fsverity",
+	"ima":          "ima",
+	"capabilities": "capabilities",
+}
+
+// metadataFeatures lists the features whose values describe an element
+// rather than its content.
+var metadataFeatures = map[string]bool{
+	"posix":        true,
+	"derivation":   true,
+	"ima":          true,
+	"capabilities": true,
+}
+
+func elementFeature(key string, e *Element) string {
+	if feature, ok := elementFeatures[key]; ok {
+		return feature
+	}
+	if e.Type == "directory" {
+		return "directory"
+	}
+	return "file"
+}
+
+// elementAlias has the fields of Element without its JSON methods.
+type elementAlias Element
+
+// elementKeys are the JSON keys of the fields of Element.
+var elementKeys = func() map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(Element{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}()
+
+// MarshalJSON writes the digests of Digests as top-level keys. Fields take
+// precedence over digests of the same name.
+func (e Element) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(elementAlias(e))
+	if err != nil || len(e.Digests) == 0 {
+		return b, err
+	}
+	m := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for algorithm, digest := range e.Digests {
+		if _, ok := m[algorithm]; ok || digest == "" {
+			continue
+		}
+		if m[algorithm], err = json.Marshal(digest); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON collects the string valued keys without a field of their own
+// into Digests.
+func (e *Element) UnmarshalJSON(b []byte) error {
+	var a elementAlias
+	if err := json.Unmarshal(b, &a); err != nil {
+		return err
+	}
+	m := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	for key, value := range m {
+		var digest string
+		if elementKeys[key] || json.Unmarshal(value, &digest) != nil {
+			continue
+		}
+		if a.Digests == nil {
+			a.Digests = make(map[string]string)
+		}
+		a.Digests[key] = digest
+	}
+	*e = Element(a)
+	return nil
+}
+
+// elementJSON returns the generic JSON representation of an element.
+func elementJSON(e *Element) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	if e == nil {
+		return m, nil
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// flattenElement returns every leaf value of an element keyed by its dotted
+// JSON path, for example "sha256" or "posix.permissions". The fields are
+// read by their JSON tags, as they would be serialized.
+func flattenElement(e *Element) map[string]string {
+	res := make(map[string]string)
+	if e == nil {
+		return res
+	}
+	flattenValue("", reflect.ValueOf(elementAlias(*e)), res)
+	addDigests(res, e.Digests)
+	return res
+}
+
+// addDigests adds the digests without a field of their own, as MarshalJSON
+// does.
+func addDigests(res map[string]string, digests map[string]string) {
+	keys := make(map[string]bool)
+	for key := range res {
+		top, _, _ := strings.Cut(key, ".")
+		keys[top] = true
+	}
+	for algorithm, digest := range digests {
+		if !keys[algorithm] && digest != "" {
+			res[algorithm] = digest
+		}
+	}
+}
+
+func flattenValue(prefix string, v reflect.Value, res map[string]string) {
+	child := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return prefix + "." + name
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			res[prefix] = "null"
+			return
+		}
+		flattenValue(prefix, v.Elem(), res)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, options, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			if options == "omitempty" && isEmptyJSON(v.Field(i)) {
+				continue
+			}
+			flattenValue(child(name), v.Field(i), res)
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			res[prefix] = "null"
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			flattenValue(child(iter.Key().String()), iter.Value(), res)
+		}
+	case reflect.String:
+		res[prefix] = v.String()
+	default:
+		// elements only hold strings, besides lists of strings
+		b, _ := json.Marshal(v.Interface())
+		res[prefix] = string(b)
+	}
+}
+
+// isEmptyJSON reports whether omitempty leaves out a value.
+func isEmptyJSON(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+// elementDigests returns the content identities of an element keyed by
+// algorithm, for example "sha256" or "gitoid:sha1".
+func elementDigests(e *Element) map[string]string {
+	res := make(map[string]string)
+	v := reflect.ValueOf(*e)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" || name == "type" || v.Field(i).Kind() != reflect.String {
+			continue
+		}
+		if s := v.Field(i).String(); s != "" {
+			res[name] = s
+		}
+	}
+	addDigests(res, e.Digests)
+	return res
+}
+
+// sameContent reports whether two elements share at least one digest
+// algorithm and agree on every digest they have in common.
+func sameContent(a, b *Element) bool {
+	ad := elementDigests(a)
+	bd := elementDigests(b)
+	common := 0
+	for algorithm, digest := range ad {
+		other, ok := bd[algorithm]
+		if !ok {
+			continue
+		}
+		if other != digest {
+			return false
+		}
+		common++
+	}
+	return common > 0
+}
+
+func (e *Element) clone() *Element {
+	if e == nil {
+		return nil
+	}
+	c := *e
+	if e.Posix != nil {
+		p := *e.Posix
+		if e.Posix.ExtendedAttributes != nil {
+			p.ExtendedAttributes = make(map[string]string, len(e.Posix.ExtendedAttributes))
+			for k, v := range e.Posix.ExtendedAttributes {
+				p.ExtendedAttributes[k] = v
+			}
+		}
+		if e.Posix.Symlink != nil {
+			symlink := *e.Posix.Symlink
+			p.Symlink = &symlink
+		}
+		c.Posix = &p
+	}
+	if e.Derivation != nil {
+		d := *e.Derivation
+		d.Inputs = append([]string{}, e.Derivation.Inputs...)
+		c.Derivation = &d
+	}
+	if e.FsVerity != nil {
+		v := *e.FsVerity
+		c.FsVerity = &v
+	}
+	if e.IMA != nil {
+		ima := *e.IMA
+		c.IMA = &ima
+	}
+	if e.Capabilities != nil {
+		capabilities := *e.Capabilities
+		capabilities.Permitted = append([]string(nil), e.Capabilities.Permitted...)
+		capabilities.Inheritable = append([]string(nil), e.Capabilities.Inheritable...)
+		capabilities.Effective = append([]string(nil), e.Capabilities.Effective...)
+		c.Capabilities = &capabilities
+	}
+	if e.Digests != nil {
+		c.Digests = make(map[string]string, len(e.Digests))
+		for k, v := range e.Digests {
+			c.Digests[k] = v
+		}
+	}
+	return &c
+}
+
+// envelopeRoot returns the key every other key of the mapping lives under,
+// or an empty string when the mapping has no single root.
+func envelopeRoot(mapping map[string]*Element) string {
+	root := ""
+	for key := range mapping {
+		if root == "" || len(key) < len(root) {
+			root = key
+		}
+	}
+	for key := range mapping {
+		if !isWithin(key, root) {
+			return ""
+		}
+	}
+	return root
+}
+
+// isWithin reports whether path is root or a descendant of root.
+func isWithin(path, root string) bool {
+	if path == root {
+		return true
+	}
+	if strings.HasSuffix(root, string(filepath.Separator)) {
+		return strings.HasPrefix(path, root)
+	}
+	return strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// relativeMapping rekeys a mapping by slash separated paths relative to root.
+// The root itself becomes ".". An empty root keeps the original keys.
+func relativeMapping(mapping map[string]*Element, root string) map[string]*Element {
+	res := make(map[string]*Element, len(mapping))
+	for key, element := range mapping {
+		res[relativeKey(root, key)] = element
+	}
+	return res
+}
+
+func relativeKey(root, key string) string {
+	if root == "" {
+		return filepath.ToSlash(key)
+	}
+	rel, err := filepath.Rel(root, key)
+	if err != nil {
+		return filepath.ToSlash(key)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func absoluteKey(root, rel string) string {
+	if root == "" {
+		return filepath.FromSlash(rel)
+	}
+	return filepath.Join(root, filepath.FromSlash(rel))
+}
+
+// parentPath returns the parent of a slash separated path, and false once
+// the top of the tree is reached.
+func parentPath(p string) (string, bool) {
+	parent := path.Dir(p)
+	if parent == p {
+		return "", false
+	}
+	return parent, true
+}
+
+func sortedKeys(m map[string]*Element) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func escapeJSONPointer(s string) string {
+	s = strings.ReplaceAll(s, "~", "~0")
+	return strings.ReplaceAll(s, "/", "~1")
+}
+
+func mappingPointer(key string) string {
+	return fmt.Sprintf("/mapping/%s", escapeJSONPointer(key))
+}
@@ -11,9 +11,17 @@ type factoryImpl struct {
 	envelope  *Envelope
 	Plugins   []Plugin
 	AllowList []string
+	// output path -> ADGs of its derivation
+	derivations map[string]*ADGSet
+	// err is a configuration error reported by Add
+	err error
 }
 
 func (factory *factoryImpl) Add(originalPath string) error {
+	if factory.err != nil {
+		return factory.err
+	}
+
 	// Convert the path to an absolute path
 	absPath, err := filepath.Abs(originalPath)
 	if err != nil {
@@ -72,6 +80,11 @@ func (factory *factoryImpl) Add(originalPath string) error {
 		}
 	}
 
+	// plugins outside of the policy may still produce disallowed digests
+	if policy := factory.envelope.Header.Policy; policy != nil {
+		return policy.Check(factory.envelope)
+	}
+
 	return nil
 }
 
@@ -80,6 +93,11 @@ func (factory *factoryImpl) Sha1ADGs() map[string]string {
 	for _, plugin := range factory.Plugins {
 		plugin.Sha1ADG(m)
 	}
+	for _, adgs := range factory.derivations {
+		for k, v := range adgs.Sha1 {
+			m[k] = v
+		}
+	}
 	return m
 }
 
@@ -89,6 +107,11 @@ func (factory *factoryImpl) Sha256ADGs() map[string]string {
 	for _, plugin := range factory.Plugins {
 		plugin.Sha256ADG(m)
 	}
+	for _, adgs := range factory.derivations {
+		for k, v := range adgs.Sha256 {
+			m[k] = v
+		}
+	}
 	return m
 }
 
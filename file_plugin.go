@@ -1,22 +1,23 @@
 package omnitrail
 
 import (
-	"crypto/sha1"
-	"crypto/sha256"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 
-	"github.com/edwarnicke/gitoid"
+	"github.com/pjbgf/sha1cd"
 )
 
 type FilePlugin struct {
 	algorithms []string
 	files      map[string]map[string]string
-	AllowList  []string
+	// device and inode -> first path hashed, so hardlinks are hashed once
+	inodes    map[fileIdentity]string
+	AllowList []string
 }
 
 func (plug *FilePlugin) isAllowedDirectory(path string) bool {
@@ -62,9 +63,36 @@ func NewFilePlugin() Plugin {
 	return &FilePlugin{
 		algorithms: algorithms,
 		files:      files,
+		inodes:     make(map[fileIdentity]string),
 	}
 }
 
+// Configure enables the file algorithms of the options in addition to the
+// defaults, and drops the ones the policy does not allow. Algorithms must be
+// registered with RegisterDigest.
+func (plug *FilePlugin) Configure(o *Options) {
+	plug.algorithms = appendMissing(plug.algorithms, o.FileAlgorithms...)
+	if policy, ok := LookupPolicy(o.Policy); ok {
+		plug.algorithms = policy.filter(plug.algorithms)
+		for algorithm := range plug.files {
+			if !policy.Allows(algorithm) {
+				delete(plug.files, algorithm)
+			}
+		}
+	}
+	sort.Strings(plug.algorithms)
+	for _, algorithm := range plug.algorithms {
+		if _, ok := plug.files[algorithm]; !ok {
+			plug.files[algorithm] = make(map[string]string)
+		}
+	}
+}
+
+// Algorithms returns the file algorithms enabled by Configure.
+func (plug *FilePlugin) Algorithms() []string {
+	return plug.algorithms
+}
+
 func (plug *FilePlugin) Add(filePath string) error {
 
 	// ignore broken symlink
@@ -106,6 +134,14 @@ func (plug *FilePlugin) Add(filePath string) error {
 		return nil
 	}
 
+	id, _, hasID := fileIdentityOf(fileInfo)
+	if first, ok := plug.inodes[id]; hasID && ok {
+		for _, hashAlgo := range plug.algorithms {
+			plug.files[hashAlgo][filePath] = plug.files[hashAlgo][first]
+		}
+		return nil
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		return err
@@ -116,48 +152,38 @@ func (plug *FilePlugin) Add(filePath string) error {
 		_ = file.Close()
 	}(file)
 
-	for _, hashAlgo := range plug.algorithms {
-
-		_, err := file.Seek(0, 0)
-		if err != nil {
-			return err
+	// hash the file once, feeding every algorithm at the same time
+	hashers := make([]hash.Hash, len(plug.algorithms))
+	writers := make([]io.Writer, len(plug.algorithms))
+	for i, hashAlgo := range plug.algorithms {
+		algorithm, ok := LookupDigest(hashAlgo)
+		if !ok {
+			return fmt.Errorf("unknown file algorithm %s", hashAlgo)
 		}
-
-		if strings.HasPrefix(hashAlgo, "gitoid:") {
-
-			var hashResult *gitoid.GitOID
-
-			switch hashAlgo {
-			case "gitoid:sha1":
-				hashResult, err = gitoid.New(file, gitoid.WithContentLength(fileInfo.Size()))
-			case "gitoid:sha256":
-				hashResult, err = gitoid.New(file, gitoid.WithContentLength(fileInfo.Size()), gitoid.WithSha256())
-			}
-
-			if err != nil {
-				return err
-			}
-
-			plug.files[hashAlgo][filePath] = hashResult.String()
-
-		} else {
-
-			switch hashAlgo {
-			case "sha1":
-				hasher := sha1.New()
-				_, err = io.Copy(hasher, file)
-				hashBytes := hasher.Sum([]byte{})
-				plug.files[hashAlgo][filePath] = fmt.Sprintf("%x", hashBytes)
-
-			case "sha256":
-				hasher := sha256.New()
-				_, err = io.Copy(hasher, file)
-				hashBytes := hasher.Sum([]byte{})
-				plug.files[hashAlgo][filePath] = fmt.Sprintf("%x", hashBytes)
+		hashers[i] = algorithm.New(fileInfo.Size())
+		writers[i] = hashers[i]
+	}
+	written, err := io.Copy(io.MultiWriter(writers...), file)
+	if err != nil {
+		return err
+	}
+	if written != fileInfo.Size() {
+		return fmt.Errorf("%s changed size while hashing", filePath)
+	}
+	for _, hasher := range hashers {
+		if detector, ok := hasher.(sha1cd.CollisionResistantHash); ok {
+			if _, collision := detector.CollisionResistantSum(nil); collision {
+				return fmt.Errorf("%s: %w", filePath, ErrSHA1Collision)
 			}
-
 		}
+	}
+	for i, hashAlgo := range plug.algorithms {
+		algorithm, _ := LookupDigest(hashAlgo)
+		plug.files[hashAlgo][filePath] = algorithm.encode(hashers[i].Sum(nil))
+	}
 
+	if hasID {
+		plug.inodes[id] = filePath
 	}
 
 	return nil
@@ -183,6 +209,11 @@ func (plug *FilePlugin) Store(envelope *Envelope) error {
 					e.Sha1Gitoid = hash
 				case "gitoid:sha256":
 					e.Sha256Gitoid = hash
+				default:
+					if e.Digests == nil {
+						e.Digests = make(map[string]string)
+					}
+					e.Digests[algorithm] = hash
 				}
 				envelope.Mapping[path] = e
 			}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package omnitrail
+
+import (
+	"os"
+)
+
+type fileIdentity struct {
+	device uint64
+	inode  uint64
+}
+
+// fileIdentityOf returns the device and inode of a file, and its number of
+// links. They are not available on this platform.
+func fileIdentityOf(_ os.FileInfo) (fileIdentity, uint64, bool) {
+	return fileIdentity{}, 0, false
+}
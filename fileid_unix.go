@@ -0,0 +1,23 @@
+//go:build linux || darwin
+
+package omnitrail
+
+import (
+	"os"
+	"syscall"
+)
+
+type fileIdentity struct {
+	device uint64
+	inode  uint64
+}
+
+// fileIdentityOf returns the device and inode of a file, and its number of
+// links.
+func fileIdentityOf(info os.FileInfo) (fileIdentity, uint64, bool) {
+	statt, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileIdentity{}, 0, false
+	}
+	return fileIdentity{device: uint64(statt.Dev), inode: uint64(statt.Ino)}, uint64(statt.Nlink), true
+}
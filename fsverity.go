@@ -0,0 +1,349 @@
+package omnitrail
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/bits"
+	"os"
+	"strconv"
+)
+
+func init() {
+	RegisterOptionalPlugin("fsverity", NewFsVerityPlugin)
+}
+
+const (
+	fsVerityDefaultBlockSize = 4096
+	fsVerityMaxSaltSize      = 32
+	fsVerityHashSHA256       = 1
+	fsVerityHashSHA512       = 2
+)
+
+var (
+	// errVerityNotEnabled is returned by measureVerity for files without
+	// fs-verity, or on file systems and platforms that do not support it.
+	errVerityNotEnabled = errors.New("fs-verity is not enabled")
+	// errVerityUnsupported is returned by parseVerityDescriptor for
+	// descriptors of a hash algorithm other than sha256.
+	errVerityUnsupported = errors.New("unsupported fs-verity hash algorithm")
+	// errVerityInvalid is returned by parseVerityDescriptor for descriptors
+	// that are truncated or malformed.
+	errVerityInvalid = errors.New("invalid fs-verity descriptor")
+)
+
+// verityAlgorithmName names an fs-verity hash algorithm.
+func verityAlgorithmName(algorithm uint16) string {
+	switch algorithm {
+	case fsVerityHashSHA256:
+		return "sha256"
+	case fsVerityHashSHA512:
+		return "sha512"
+	}
+	return strconv.Itoa(int(algorithm))
+}
+
+// FsVerityPlugin computes the fs-verity file digest of every file, the
+// sha256 of its fsverity_descriptor, which is what the kernel reports and
+// signatures cover. Files that already have fs-verity enabled are measured
+// with FS_IOC_MEASURE_VERITY, and the kernel digest is compared with the
+// computed one. If verity was enabled with another block size or salt than
+// the trail uses, the digest is computed again with the parameters of the
+// file's own descriptor, and recorded with them.
+type FsVerityPlugin struct {
+	blockSize int
+	salt      []byte
+	files     map[string]*FsVerity
+	// the kernel interface, replaced in tests as fs-verity needs a file
+	// system that supports it
+	measure        func(*os.File) (uint16, []byte, error)
+	readDescriptor func(*os.File) ([]byte, error)
+	AllowList      []string
+}
+
+func NewFsVerityPlugin() Plugin {
+	return &FsVerityPlugin{
+		blockSize:      fsVerityDefaultBlockSize,
+		files:          make(map[string]*FsVerity),
+		measure:        measureVerity,
+		readDescriptor: readVerityDescriptor,
+	}
+}
+
+// WithFsVerityParameters sets the Merkle tree block size and salt of the
+// fsverity plugin. The defaults are 4096 byte blocks and no salt, as used by
+// fsverity enable.
+func WithFsVerityParameters(blockSize int, salt []byte) Option {
+	return func(o *Options) {
+		o.FsVerityBlockSize = blockSize
+		o.FsVeritySalt = salt
+	}
+}
+
+func (plug *FsVerityPlugin) Configure(o *Options) {
+	if o.FsVerityBlockSize != 0 {
+		plug.blockSize = o.FsVerityBlockSize
+	}
+	plug.salt = o.FsVeritySalt
+}
+
+func (plug *FsVerityPlugin) Add(path string) error {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		// broken symlinks are ignored, as in the file plugin
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	digest, err := fsVerityDigest(file, fileInfo.Size(), plug.blockSize, plug.salt)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	v := &FsVerity{
+		Sha256:    hex.EncodeToString(digest),
+		BlockSize: strconv.Itoa(plug.blockSize),
+		Salt:      hex.EncodeToString(plug.salt),
+	}
+	algorithm, measured, err := plug.measure(file)
+	switch {
+	case errors.Is(err, errVerityNotEnabled):
+	case err != nil:
+		return fmt.Errorf("%s: %w", path, err)
+	default:
+		err := v.compare(file, fileInfo.Size(), algorithm, measured, func() ([]byte, error) {
+			return plug.readDescriptor(file)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	plug.files[path] = v
+	return nil
+}
+
+// Algorithms returns the algorithm of the fs-verity digests, which are only
+// computed with sha256.
+func (plug *FsVerityPlugin) Algorithms() []string {
+	return []string{"sha256"}
+}
+
+func (plug *FsVerityPlugin) Store(envelope *Envelope) error {
+	envelope.Header.Features["fsverity"] = Feature{Algorithms: []string{"sha256"}}
+	for path, v := range plug.files {
+		if element, ok := envelope.Mapping[path]; ok {
+			element.FsVerity = v
+		}
+	}
+	return nil
+}
+
+func (plug *FsVerityPlugin) Sha1ADG(map[string]string) {}
+
+func (plug *FsVerityPlugin) Sha256ADG(map[string]string) {}
+
+func (plug *FsVerityPlugin) SetAllowList(allowList []string) {
+	plug.AllowList = allowList
+}
+
+// compare sets the kernel digest and the status of a file with fs-verity
+// enabled. If the digests differ, the file may use other parameters than the
+// trail, so the digest is computed again with the block size and salt of the
+// descriptor the kernel returns, before the file is reported as a mismatch.
+// Files whose verity uses another hash algorithm than sha256, or whose
+// descriptor cannot be parsed, are recorded as unsupported or invalid; only
+// errors reading the file are returned.
+func (v *FsVerity) compare(r io.ReadSeeker, size int64, algorithm uint16, measured []byte, descriptor func() ([]byte, error)) error {
+	if algorithm != fsVerityHashSHA256 {
+		v.Status = FsVerityUnsupported
+		v.KernelAlgorithm = verityAlgorithmName(algorithm)
+		return nil
+	}
+	v.KernelSha256 = hex.EncodeToString(measured)
+	v.Status = FsVerityVerified
+	if v.KernelSha256 == v.Sha256 {
+		return nil
+	}
+	d, err := descriptor()
+	if err != nil {
+		return err
+	}
+	blockSize, salt, err := parseVerityDescriptor(d)
+	switch {
+	case errors.Is(err, errVerityUnsupported):
+		v.Status = FsVerityUnsupported
+		v.KernelAlgorithm = verityAlgorithmName(uint16(d[1]))
+		return nil
+	case errors.Is(err, errVerityInvalid):
+		v.Status = FsVerityInvalid
+		return nil
+	case err != nil:
+		return err
+	}
+	if strconv.Itoa(blockSize) != v.BlockSize || hex.EncodeToString(salt) != v.Salt {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		digest, err := fsVerityDigest(r, size, blockSize, salt)
+		if err != nil {
+			return err
+		}
+		v.Sha256 = hex.EncodeToString(digest)
+		v.BlockSize = strconv.Itoa(blockSize)
+		v.Salt = hex.EncodeToString(salt)
+	}
+	if v.KernelSha256 != v.Sha256 {
+		v.Status = FsVerityMismatch
+	}
+	return nil
+}
+
+// parseVerityDescriptor returns the block size and salt of a struct
+// fsverity_descriptor.
+func parseVerityDescriptor(d []byte) (int, []byte, error) {
+	if len(d) < 256 {
+		return 0, nil, fmt.Errorf("%w: truncated to %d bytes", errVerityInvalid, len(d))
+	}
+	if d[0] != 1 {
+		return 0, nil, fmt.Errorf("%w: unknown version %d", errVerityInvalid, d[0])
+	}
+	if d[1] != fsVerityHashSHA256 {
+		return 0, nil, fmt.Errorf("%w %s", errVerityUnsupported, verityAlgorithmName(uint16(d[1])))
+	}
+	if d[3] > fsVerityMaxSaltSize {
+		return 0, nil, fmt.Errorf("%w: salt is longer than %d bytes", errVerityInvalid, fsVerityMaxSaltSize)
+	}
+	if d[2] < 10 || d[2] > 16 {
+		return 0, nil, fmt.Errorf("%w: block size 2^%d", errVerityInvalid, d[2])
+	}
+	return 1 << d[2], append([]byte(nil), d[80:80+int(d[3])]...), nil
+}
+
+// fsVerityDigest computes the fs-verity file digest of the size bytes read
+// from r, as described in the kernel's fsverity documentation.
+func fsVerityDigest(r io.Reader, size int64, blockSize int, salt []byte) ([]byte, error) {
+	if blockSize < 1024 || blockSize > 65536 || blockSize&(blockSize-1) != 0 {
+		return nil, fmt.Errorf("invalid fs-verity block size %d", blockSize)
+	}
+	if len(salt) > fsVerityMaxSaltSize {
+		return nil, fmt.Errorf("fs-verity salt is longer than %d bytes", fsVerityMaxSaltSize)
+	}
+	root, err := fsVerityRootHash(r, size, blockSize, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptor := fsVerityDescriptor(size, blockSize, salt, root)
+	sum := sha256.Sum256(descriptor)
+	return sum[:], nil
+}
+
+// fsVerityDescriptor renders a struct fsverity_descriptor.
+func fsVerityDescriptor(size int64, blockSize int, salt, root []byte) []byte {
+	descriptor := make([]byte, 256)
+	descriptor[0] = 1 // version
+	descriptor[1] = fsVerityHashSHA256
+	descriptor[2] = byte(bits.TrailingZeros(uint(blockSize)))
+	descriptor[3] = byte(len(salt))
+	// the signature size at offset 4 is zero when computing the digest
+	binary.LittleEndian.PutUint64(descriptor[8:], uint64(size))
+	copy(descriptor[16:], root)
+	copy(descriptor[80:], salt)
+	return descriptor
+}
+
+// fsVerityRootHash computes the root of the Merkle tree over the blocks of
+// the file. Every block is hashed with the salt, padded to the block size of
+// the hash, in front of it, and every level is padded with zeros to a
+// multiple of the block size. An empty file has a root hash of zeros.
+func fsVerityRootHash(r io.Reader, size int64, blockSize int, salt []byte) ([]byte, error) {
+	if size == 0 {
+		return make([]byte, sha256.Size), nil
+	}
+	h := sha256.New()
+	var padded []byte
+	if len(salt) > 0 {
+		padded = make([]byte, (len(salt)+h.BlockSize()-1)/h.BlockSize()*h.BlockSize())
+		copy(padded, salt)
+	}
+	hashBlock := func(h hash.Hash, block []byte) []byte {
+		h.Reset()
+		h.Write(padded)
+		h.Write(block)
+		return h.Sum(nil)
+	}
+
+	block := make([]byte, blockSize)
+	var level []byte
+	for remaining := size; remaining > 0; {
+		n := int64(blockSize)
+		if remaining < n {
+			n = remaining
+		}
+		if _, err := io.ReadFull(r, block[:n]); err != nil {
+			return nil, err
+		}
+		for i := n; i < int64(blockSize); i++ {
+			block[i] = 0
+		}
+		level = append(level, hashBlock(h, block)...)
+		remaining -= n
+	}
+
+	for len(level) > sha256.Size {
+		var next []byte
+		for i := 0; i < len(level); i += blockSize {
+			for j := range block {
+				block[j] = 0
+			}
+			copy(block, level[i:])
+			next = append(next, hashBlock(h, block)...)
+		}
+		level = next
+	}
+	return level, nil
+}
+
+const (
+	// FsVerityVerified is set on files with fs-verity enabled whose kernel
+	// digest matches the computed digest
+	FsVerityVerified = "verified"
+	// FsVerityMismatch is set on files with fs-verity enabled whose kernel
+	// digest differs from the computed digest
+	FsVerityMismatch = "mismatch"
+	// FsVerityUnsupported is set on files with fs-verity enabled with
+	// another hash algorithm than sha256, which is recorded as the kernel
+	// algorithm
+	FsVerityUnsupported = "unsupported"
+	// FsVerityInvalid is set on files with fs-verity enabled whose
+	// descriptor cannot be parsed
+	FsVerityInvalid = "invalid"
+)
+
+// FsVerity is the fs-verity digest of a file. KernelSha256 and Status are
+// only set for files that have fs-verity enabled, and KernelAlgorithm only
+// for files whose verity is not sha256 based.
+type FsVerity struct {
+	Sha256          string `json:"sha256"`
+	BlockSize       string `json:"block_size"`
+	Salt            string `json:"salt,omitempty"`
+	KernelSha256    string `json:"kernel_sha256,omitempty"`
+	KernelAlgorithm string `json:"kernel_algorithm,omitempty"`
+	Status          string `json:"status,omitempty"`
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package omnitrail
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsverityDigest is struct fsverity_digest with room for a sha512 digest.
+type fsverityDigest struct {
+	algorithm uint16
+	size      uint16
+	digest    [64]byte
+}
+
+// measureVerity returns the hash algorithm and digest the kernel reports for
+// a file with fs-verity enabled.
+func measureVerity(file *os.File) (uint16, []byte, error) {
+	d := fsverityDigest{size: uint16(len(fsverityDigest{}.digest))}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, file.Fd(), unix.FS_IOC_MEASURE_VERITY, uintptr(unsafe.Pointer(&d)))
+	switch {
+	case errno == 0:
+	case errors.Is(errno, unix.ENODATA), errors.Is(errno, unix.ENOTTY), errors.Is(errno, unix.EOPNOTSUPP):
+		return 0, nil, errVerityNotEnabled
+	default:
+		return 0, nil, &os.PathError{Op: "FS_IOC_MEASURE_VERITY", Path: file.Name(), Err: errno}
+	}
+	return d.algorithm, d.digest[:d.size], nil
+}
+
+// fsverityReadMetadataArg is struct fsverity_read_metadata_arg.
+type fsverityReadMetadataArg struct {
+	metadataType uint64
+	offset       uint64
+	length       uint64
+	bufPtr       uint64
+	reserved     uint64
+}
+
+// readVerityDescriptor returns the fsverity_descriptor of a file with
+// fs-verity enabled, which records the parameters verity was enabled with.
+func readVerityDescriptor(file *os.File) ([]byte, error) {
+	buf := make([]byte, 256)
+	arg := fsverityReadMetadataArg{
+		metadataType: unix.FS_VERITY_METADATA_TYPE_DESCRIPTOR,
+		length:       uint64(len(buf)),
+		bufPtr:       uint64(uintptr(unsafe.Pointer(&buf[0]))),
+	}
+	n, _, errno := unix.Syscall(unix.SYS_IOCTL, file.Fd(), unix.FS_IOC_READ_VERITY_METADATA, uintptr(unsafe.Pointer(&arg)))
+	if errno != 0 {
+		return nil, &os.PathError{Op: "FS_IOC_READ_VERITY_METADATA", Path: file.Name(), Err: errno}
+	}
+	return buf[:n], nil
+}
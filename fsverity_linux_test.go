@@ -0,0 +1,47 @@
+//go:build linux
+
+package omnitrail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func enableVerity(t *testing.T, path string) {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	arg := unix.FsverityEnableArg{Version: 1, Hash_algorithm: fsVerityHashSHA256, Block_size: fsVerityDefaultBlockSize}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.FS_IOC_ENABLE_VERITY, uintptr(unsafe.Pointer(&arg)))
+	if errno != 0 {
+		t.Skipf("fs-verity cannot be enabled on %s: %v", path, errno)
+	}
+}
+
+func TestMeasureVerity(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data")
+	require.NoError(t, os.WriteFile(path, verityTestData(), 0644))
+
+	trail := NewTrail(WithPlugins("fsverity"))
+	require.NoError(t, trail.Add(dir))
+	assert.Empty(t, trail.Envelope().Mapping[path].FsVerity.Status)
+
+	enableVerity(t, path)
+	trail = NewTrail(WithPlugins("fsverity"))
+	require.NoError(t, trail.Add(dir))
+	v := trail.Envelope().Mapping[path].FsVerity
+	assert.Equal(t, FsVerityVerified, v.Status)
+	assert.Equal(t, v.Sha256, v.KernelSha256)
+
+	// the parameters verity was enabled with are read from the file
+	trail = NewTrail(WithPlugins("fsverity"), WithFsVerityParameters(1024, []byte("omnitrail")))
+	require.NoError(t, trail.Add(dir))
+	assert.Equal(t, v, trail.Envelope().Mapping[path].FsVerity)
+}
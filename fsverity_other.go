@@ -0,0 +1,19 @@
+//go:build !linux
+
+package omnitrail
+
+import (
+	"os"
+)
+
+// measureVerity returns the hash algorithm and digest the kernel reports for
+// a file with fs-verity enabled. fs-verity is only available on Linux.
+func measureVerity(_ *os.File) (uint16, []byte, error) {
+	return 0, nil, errVerityNotEnabled
+}
+
+// readVerityDescriptor returns the fsverity_descriptor of a file with
+// fs-verity enabled. fs-verity is only available on Linux.
+func readVerityDescriptor(_ *os.File) ([]byte, error) {
+	return nil, errVerityNotEnabled
+}
@@ -0,0 +1,182 @@
+package omnitrail
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// verityTestData spans several levels of the Merkle tree with 1024 byte
+// blocks.
+func verityTestData() []byte {
+	b := make([]byte, 300000)
+	for i := range b {
+		b[i] = byte((i*7 + 3) % 251)
+	}
+	return b
+}
+
+// The digest of the empty file is the one fsverity digest reports. The
+// others were cross-checked with a separate implementation of the
+// fsverity_descriptor, as fs-verity is not available to the tests.
+func TestFsVerityDigest(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		data      []byte
+		blockSize int
+		salt      []byte
+		expected  string
+	}{
+		{"empty", nil, 4096, nil, "3d248ca542a24fc62d1c43b916eae5016878e2533c88238480b26128a1f1af95"},
+		{"one block", []byte("hello world\n"), 4096, nil, "37061ef2ac4c21bec68489b56138c5780306a4ad7fe6676236ecdf2c9027cd92"},
+		{"tree", verityTestData(), 4096, nil, "a5247b300ab48c44f960ff87f47b1c8842b56313044192028a9c02c9e25c6bca"},
+		{"salted tree", verityTestData(), 1024, []byte("omnitrail"), "e5aa98380d5dff18ce926d500510435f2da564dde75ae729d2ae1f9afd074938"},
+	} {
+		digest, err := fsVerityDigest(bytes.NewReader(tt.data), int64(len(tt.data)), tt.blockSize, tt.salt)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.expected, hex.EncodeToString(digest), tt.name)
+	}
+
+	_, err := fsVerityDigest(bytes.NewReader(nil), 0, 1000, nil)
+	assert.Error(t, err)
+	_, err = fsVerityDigest(bytes.NewReader(nil), 0, 4096, make([]byte, 33))
+	assert.Error(t, err)
+	_, err = fsVerityDigest(bytes.NewReader([]byte("short")), 10, 4096, nil)
+	assert.Error(t, err)
+}
+
+func TestFsVerityPlugin(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data")
+	require.NoError(t, os.WriteFile(path, verityTestData(), 0644))
+	require.NoError(t, os.Symlink("data", filepath.Join(dir, "link")))
+
+	trail := NewTrail(WithPlugins("fsverity"), WithFsVerityParameters(1024, []byte("omnitrail")))
+	require.NoError(t, trail.Add(dir))
+	envelope := trail.Envelope()
+	assert.Equal(t, []string{"sha256"}, envelope.Header.Features["fsverity"].Algorithms)
+	assert.Equal(t, &FsVerity{
+		Sha256:    "e5aa98380d5dff18ce926d500510435f2da564dde75ae729d2ae1f9afd074938",
+		BlockSize: "1024",
+		Salt:      hex.EncodeToString([]byte("omnitrail")),
+	}, envelope.Mapping[path].FsVerity)
+	assert.Equal(t, envelope.Mapping[path].FsVerity, envelope.Mapping[filepath.Join(dir, "link")].FsVerity)
+	assert.Nil(t, envelope.Mapping[dir].FsVerity)
+}
+
+// TestFsVerityUnsupported checks that files with verity built on another
+// hash than sha256 do not stop the trail.
+func TestFsVerityUnsupported(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data")
+	data := verityTestData()
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	root, err := fsVerityRootHash(bytes.NewReader(data), int64(len(data)), 4096, nil)
+	require.NoError(t, err)
+	descriptor := fsVerityDescriptor(int64(len(data)), 4096, nil, root)
+	descriptor[1] = fsVerityHashSHA512
+
+	plug := NewFsVerityPlugin().(*FsVerityPlugin)
+	// the kernel measures a sha256 digest of another descriptor
+	plug.measure = func(*os.File) (uint16, []byte, error) {
+		return fsVerityHashSHA256, make([]byte, 32), nil
+	}
+	plug.readDescriptor = func(*os.File) ([]byte, error) {
+		return descriptor, nil
+	}
+	require.NoError(t, plug.Add(dir))
+	require.NoError(t, plug.Add(path))
+	envelope := &Envelope{
+		Header:  Header{Features: make(map[string]Feature)},
+		Mapping: map[string]*Element{dir: {Type: "directory"}, path: {Type: "file"}},
+	}
+	require.NoError(t, plug.Store(envelope))
+	assert.Equal(t, FsVerityUnsupported, envelope.Mapping[path].FsVerity.Status)
+	assert.Equal(t, "sha512", envelope.Mapping[path].FsVerity.KernelAlgorithm)
+}
+
+// TestFsVerityCompare covers files that had verity enabled with other
+// parameters than the trail, with descriptors as the kernel returns them.
+func TestFsVerityCompare(t *testing.T) {
+	data := verityTestData()
+	salt := []byte("omnitrail")
+	root, err := fsVerityRootHash(bytes.NewReader(data), int64(len(data)), 1024, salt)
+	require.NoError(t, err)
+	descriptor := fsVerityDescriptor(int64(len(data)), 1024, salt, root)
+	kernel, err := hex.DecodeString("e5aa98380d5dff18ce926d500510435f2da564dde75ae729d2ae1f9afd074938")
+	require.NoError(t, err)
+	computed := func() *FsVerity {
+		return &FsVerity{Sha256: "a5247b300ab48c44f960ff87f47b1c8842b56313044192028a9c02c9e25c6bca", BlockSize: "4096"}
+	}
+	read := func() ([]byte, error) {
+		return descriptor, nil
+	}
+
+	v := computed()
+	require.NoError(t, v.compare(bytes.NewReader(data), int64(len(data)), fsVerityHashSHA256, kernel, read))
+	assert.Equal(t, &FsVerity{
+		Sha256:       hex.EncodeToString(kernel),
+		BlockSize:    "1024",
+		Salt:         hex.EncodeToString(salt),
+		KernelSha256: hex.EncodeToString(kernel),
+		Status:       FsVerityVerified,
+	}, v)
+
+	// other content than verity was enabled on
+	tampered := append([]byte(nil), data...)
+	tampered[0]++
+	v = computed()
+	require.NoError(t, v.compare(bytes.NewReader(tampered), int64(len(tampered)), fsVerityHashSHA256, kernel, read))
+	assert.Equal(t, FsVerityMismatch, v.Status)
+	assert.Equal(t, "1024", v.BlockSize)
+	assert.NotEqual(t, v.KernelSha256, v.Sha256)
+
+	// matching digests need no descriptor
+	v = computed()
+	measured, err := hex.DecodeString(v.Sha256)
+	require.NoError(t, err)
+	require.NoError(t, v.compare(bytes.NewReader(data), int64(len(data)), fsVerityHashSHA256, measured, nil))
+	assert.Equal(t, FsVerityVerified, v.Status)
+
+	// a file whose verity is sha512 based, as the kernel measures it
+	v = computed()
+	require.NoError(t, v.compare(bytes.NewReader(data), int64(len(data)), fsVerityHashSHA512, make([]byte, 64), nil))
+	assert.Equal(t, &FsVerity{
+		Sha256:          "a5247b300ab48c44f960ff87f47b1c8842b56313044192028a9c02c9e25c6bca",
+		BlockSize:       "4096",
+		KernelAlgorithm: "sha512",
+		Status:          FsVerityUnsupported,
+	}, v)
+
+	sha512 := append([]byte{1, fsVerityHashSHA512}, descriptor[2:]...)
+	for name, test := range map[string]struct {
+		descriptor []byte
+		status     string
+		algorithm  string
+	}{
+		"sha512":     {sha512, FsVerityUnsupported, "sha512"},
+		"truncated":  {descriptor[:100], FsVerityInvalid, ""},
+		"version":    {append([]byte{2}, descriptor[1:]...), FsVerityInvalid, ""},
+		"block size": {append([]byte{1, fsVerityHashSHA256, 9}, descriptor[3:]...), FsVerityInvalid, ""},
+	} {
+		v = computed()
+		require.NoError(t, v.compare(bytes.NewReader(data), int64(len(data)), fsVerityHashSHA256, kernel, func() ([]byte, error) {
+			return test.descriptor, nil
+		}), name)
+		assert.Equal(t, test.status, v.Status, name)
+		assert.Equal(t, test.algorithm, v.KernelAlgorithm, name)
+		// the digest of the trail's own parameters is kept
+		assert.Equal(t, "4096", v.BlockSize, name)
+	}
+
+	// errors reading the descriptor are returned
+	v = computed()
+	assert.Error(t, v.compare(bytes.NewReader(data), int64(len(data)), fsVerityHashSHA256, kernel, func() ([]byte, error) {
+		return nil, os.ErrPermission
+	}))
+}
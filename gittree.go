@@ -0,0 +1,148 @@
+package omnitrail
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// gitEntry is a directory entry as git sees it, taken from lstat. Symlinks
+// are stored by git as a blob holding the link target.
+type gitEntry struct {
+	mode   os.FileMode
+	target string
+}
+
+type gitTreeEntry struct {
+	mode string
+	name string
+	hash []byte
+}
+
+func gitObjectHash(objectType string, content []byte, sha256Format bool) []byte {
+	var h hash.Hash
+	if sha256Format {
+		h = sha256.New()
+	} else {
+		h = sha1.New()
+	}
+	fmt.Fprintf(h, "%s %d\x00", objectType, len(content))
+	h.Write(content)
+	return h.Sum(nil)
+}
+
+// gitTreeKey sorts tree entries the way git does: directories compare as
+// if their name ended with a slash.
+func gitTreeKey(e gitTreeEntry) string {
+	if e.mode == "40000" {
+		return e.name + "/"
+	}
+	return e.name
+}
+
+// buildGitTrees computes the "gittree:sha1" or "gittree:sha256" object ids of
+// the directories in entries, equal to what git write-tree returns for the
+// same content. File blobs are taken from the gitoids in the mapping, which
+// are git blob ids. As in git, empty directories are left out of their
+// parent unless keepEmpty is set, and ".git" directories and special files
+// are ignored.
+func buildGitTrees(mapping map[string]*Element, entries map[string]gitEntry, algorithm string, keepEmpty bool) (map[string]string, error) {
+	var gitoidAlgorithm string
+	switch algorithm {
+	case "gittree:sha1":
+		gitoidAlgorithm = "gitoid:sha1"
+	case "gittree:sha256":
+		gitoidAlgorithm = "gitoid:sha256"
+	default:
+		return nil, fmt.Errorf("unsupported git tree algorithm %s", algorithm)
+	}
+	sha256Format := algorithm == "gittree:sha256"
+
+	children := make(map[string][]string)
+	var directories []string
+	for path, entry := range entries {
+		if entry.mode.IsDir() {
+			directories = append(directories, path)
+		}
+		parent := filepath.Dir(path)
+		if parent == path || filepath.Base(path) == ".git" {
+			continue
+		}
+		if parent, ok := entries[parent]; !ok || !parent.mode.IsDir() {
+			continue
+		}
+		children[parent] = append(children[parent], path)
+	}
+	// children before their parents
+	sort.Slice(directories, func(i, j int) bool {
+		return len(directories[i]) > len(directories[j])
+	})
+
+	trees := make(map[string][]byte)
+	res := make(map[string]string)
+	for _, dir := range directories {
+		var tree []gitTreeEntry
+		for _, path := range children[dir] {
+			entry := entries[path]
+			e := gitTreeEntry{name: filepath.Base(path)}
+			switch {
+			case entry.mode&os.ModeSymlink != 0:
+				e.mode = "120000"
+				e.hash = gitObjectHash("blob", []byte(entry.target), sha256Format)
+			case entry.mode.IsRegular():
+				e.mode = "100644"
+				if entry.mode&0100 != 0 {
+					e.mode = "100755"
+				}
+				element, ok := mapping[path]
+				if !ok || elementGitoid(element, gitoidAlgorithm) == "" {
+					return nil, fmt.Errorf("%s of %s is not known", gitoidAlgorithm, path)
+				}
+				b, err := hex.DecodeString(elementGitoid(element, gitoidAlgorithm))
+				if err != nil {
+					return nil, fmt.Errorf("%s of %s: %w", gitoidAlgorithm, path, err)
+				}
+				e.hash = b
+			case entry.mode.IsDir():
+				if trees[path] == nil {
+					continue
+				}
+				e.mode = "40000"
+				e.hash = trees[path]
+			default:
+				continue
+			}
+			tree = append(tree, e)
+		}
+		sort.Slice(tree, func(i, j int) bool {
+			return gitTreeKey(tree[i]) < gitTreeKey(tree[j])
+		})
+
+		var content bytes.Buffer
+		for _, e := range tree {
+			fmt.Fprintf(&content, "%s %s\x00", e.mode, e.name)
+			content.Write(e.hash)
+		}
+		id := gitObjectHash("tree", content.Bytes(), sha256Format)
+		if len(tree) > 0 || keepEmpty {
+			trees[dir] = id
+		}
+		res[dir] = hex.EncodeToString(id)
+	}
+	return res, nil
+}
+
+func setElementGitTree(e *Element, algorithm string, tree string) {
+	switch algorithm {
+	case "gittree:sha1":
+		e.Sha1GitTree = tree
+	case "gittree:sha256":
+		e.Sha256GitTree = tree
+	}
+}
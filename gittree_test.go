@@ -0,0 +1,71 @@
+package omnitrail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeGitLayout creates files, an executable, a symlink, nested and empty
+// directories with fixed permissions.
+func writeGitLayout(t *testing.T) string {
+	dir, err := filepath.Abs(t.TempDir())
+	require.NoError(t, err)
+	for _, sub := range []string{"src/pkg", "empty/nested", "bin"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, sub), 0755))
+	}
+	files := map[string]string{
+		"src/main.go":    "package main\n",
+		"src/pkg/pkg.go": "package pkg\n",
+		"bin/run.sh":     "#!/bin/sh\necho hi\n",
+		"README":         "readme\n",
+		// sorts before the src directory, which git compares as "src/"
+		"src-x": "dash\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		require.NoError(t, os.Chmod(path, 0644))
+	}
+	require.NoError(t, os.Chmod(filepath.Join(dir, "bin/run.sh"), 0755))
+	require.NoError(t, os.Symlink("src/main.go", filepath.Join(dir, "link")))
+	return dir
+}
+
+// The expected tree ids were computed with git write-tree on the same
+// layout, in sha1 and sha256 repositories.
+func TestGitTrees(t *testing.T) {
+	dir := writeGitLayout(t)
+
+	trail := NewTrail(WithDirectoryAlgorithms("gittree:sha1", "gittree:sha256"))
+	require.NoError(t, trail.Add(dir))
+	envelope := trail.Envelope()
+	assert.Equal(t, []string{"gitoid:sha1", "gitoid:sha256", "gittree:sha1", "gittree:sha256"}, envelope.Header.Features["directory"].Algorithms)
+
+	root := envelope.Mapping[dir]
+	assert.Equal(t, "5eac35897569c35413470cd3176dcdd07e646f74", root.Sha1GitTree)
+	assert.Equal(t, "0857595aded535bb379c719c9ebdb5c05d85bf2a501c1bb4392ddc98169d5c13", root.Sha256GitTree)
+
+	src := envelope.Mapping[filepath.Join(dir, "src")]
+	assert.Equal(t, "de3c35ccecaa3ddc0eb02ea473767b7f351aff10", src.Sha1GitTree)
+	assert.Equal(t, "a31f2076b9b9cdbc61c882be38358c763411a58789cef9a1cae33442a7a0bac7", src.Sha256GitTree)
+	bin := envelope.Mapping[filepath.Join(dir, "bin")]
+	assert.Equal(t, "31e608648b097abeeae5708b175b2638af0a598f", bin.Sha1GitTree)
+	assert.Equal(t, "c1b145b72097411378f493840cadf5ac5309bfee52dd071c4a1a8d5581a003e8", bin.Sha256GitTree)
+
+	// empty directories have the empty tree, but are left out of their parent
+	empty := envelope.Mapping[filepath.Join(dir, "empty")]
+	assert.Equal(t, "4b825dc642cb6eb9a060e54bf8d69288fbee4904", empty.Sha1GitTree)
+	assert.Equal(t, "6ef19b41225c5369f1c104d45d8d85efa9b057b53b14b4b9b939dd74decc5321", empty.Sha256GitTree)
+
+	// git trees are opt-in
+	trail = NewTrail()
+	require.NoError(t, trail.Add(dir))
+	assert.Empty(t, trail.Envelope().Mapping[dir].Sha1GitTree)
+
+	trail = NewTrail(WithDirectoryAlgorithms("gittree:md5"))
+	assert.Error(t, trail.Add(dir))
+}
@@ -5,7 +5,10 @@ go 1.20
 require (
 	github.com/edwarnicke/gitoid v0.0.0-20220710194850-1be5bfda1f9d
 	github.com/omnibor/omnibor-go v0.0.0-20230521145532-a77de61a16cd
+	github.com/pjbgf/sha1cd v0.3.0
 	github.com/stretchr/testify v1.8.2
+	golang.org/x/crypto v0.21.0
+	golang.org/x/sys v0.18.0
 )
 
 require (
@@ -0,0 +1,337 @@
+package omnitrail
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DirHash1 computes the "h1:" hash Go records in go.sum for the files below
+// dir, equal to dirhash.Hash1 over the same files. Every file is named by
+// prefix, usually "module@version", followed by its path relative to dir.
+// The sha256 feature of the envelope is used, so no file is read.
+func DirHash1(envelope *Envelope, dir string, prefix string) (string, error) {
+	return dirHash1(envelope, dir, prefix, nil)
+}
+
+func dirHash1(envelope *Envelope, dir string, prefix string, exclude []string) (string, error) {
+	lines := make(map[string]string)
+	for path, element := range envelope.Mapping {
+		if element.Type != "file" || path == dir || !isWithin(path, dir) {
+			continue
+		}
+		excluded := false
+		for _, other := range exclude {
+			if isWithin(path, other) {
+				excluded = true
+				break
+			}
+		}
+		if excluded {
+			continue
+		}
+		name := prefix + "/" + relativeKey(dir, path)
+		if strings.Contains(name, "\n") {
+			return "", fmt.Errorf("dirhash: filename %q contains a newline", name)
+		}
+		if element.Sha256 == "" {
+			return "", fmt.Errorf("dirhash: %s has no sha256", path)
+		}
+		lines[name] = fmt.Sprintf("%s  %s\n", element.Sha256, name)
+	}
+
+	names := make([]string, 0, len(lines))
+	for name := range lines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		io.WriteString(h, lines[name])
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// moduleHashes computes the h1 hash of every directory that is a module
+// version, named "<name>@<version>" as in a module cache, and has a go.mod
+// file. The name of the directory is only the last element of the module
+// path, so the module path is read from go.mod.
+func moduleHashes(envelope *Envelope, directories []string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	for _, dir := range directories {
+		_, version, ok := strings.Cut(filepath.Base(dir), "@")
+		if !ok || version == "" {
+			continue
+		}
+		goMod := filepath.Join(dir, "go.mod")
+		if element, ok := envelope.Mapping[goMod]; !ok || element.Type != "file" {
+			continue
+		}
+		data, err := os.ReadFile(goMod)
+		if err != nil {
+			return nil, err
+		}
+		module := goModulePath(data)
+		if module == "" {
+			continue
+		}
+		hash, err := dirHash1(envelope, dir, module+"@"+version, nil)
+		if err != nil {
+			return nil, err
+		}
+		hashes[dir] = hash
+	}
+	return hashes, nil
+}
+
+// goModulePath returns the path of the module directive of a go.mod file.
+func goModulePath(goMod []byte) string {
+	for _, line := range strings.Split(string(goMod), "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], "\"`")
+		}
+	}
+	return ""
+}
+
+// GoSumEntry is a line of a go.sum file. GoMod is set for the hash of the
+// go.mod file alone, written as "<version>/go.mod".
+type GoSumEntry struct {
+	Module  string
+	Version string
+	GoMod   bool
+	Hash    string
+}
+
+type GoSum struct {
+	Entries []GoSumEntry
+}
+
+func ParseGoSum(r io.Reader) (*GoSum, error) {
+	sum := &GoSum{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("go.sum line %d: expected 3 fields, got %d", lineNumber, len(fields))
+		}
+		entry := GoSumEntry{Module: fields[0], Version: fields[1], Hash: fields[2]}
+		if strings.HasSuffix(entry.Version, "/go.mod") {
+			entry.Version = strings.TrimSuffix(entry.Version, "/go.mod")
+			entry.GoMod = true
+		}
+		sum.Entries = append(sum.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sum, nil
+}
+
+// Lookup returns the hash of the module tree, or of its go.mod file if goMod
+// is set.
+func (s *GoSum) Lookup(module, version string, goMod bool) (string, bool) {
+	for _, entry := range s.Entries {
+		if entry.Module == module && entry.Version == version && entry.GoMod == goMod {
+			return entry.Hash, true
+		}
+	}
+	return "", false
+}
+
+type GoModuleStatus string
+
+const (
+	GoModuleVerified GoModuleStatus = "verified"
+	GoModuleMismatch GoModuleStatus = "mismatch"
+	// GoModuleMissing is a module listed for the tree that is not in the trail
+	GoModuleMissing GoModuleStatus = "missing"
+	// GoModuleUnknown is a module without a go.sum entry
+	GoModuleUnknown GoModuleStatus = "unknown"
+	// GoModulePruned is a vendored module that does not match go.sum. go
+	// mod vendor leaves out go.mod files, tests, testdata and the packages
+	// no build imports, so vendored modules can rarely be verified.
+	GoModulePruned GoModuleStatus = "pruned"
+)
+
+type GoModuleResult struct {
+	Module   string         `json:"module"`
+	Version  string         `json:"version"`
+	Path     string         `json:"path"`
+	Expected string         `json:"expected,omitempty"`
+	Actual   string         `json:"actual,omitempty"`
+	Status   GoModuleStatus `json:"status"`
+}
+
+// VerifyModuleCache checks every module directory of a module cache, such
+// as $GOMODCACHE, against go.sum. Module directories are named by their
+// escaped module path and version, for example
+// "github.com/!burnt!sushi/toml@v1.3.2".
+func VerifyModuleCache(envelope *Envelope, root string, sum *GoSum) ([]GoModuleResult, error) {
+	var results []GoModuleResult
+	var modules []string
+	for _, path := range sortedKeys(envelope.Mapping) {
+		if envelope.Mapping[path].Type != "directory" || path == root || !isWithin(path, root) {
+			continue
+		}
+		rel := relativeKey(root, path)
+		if strings.HasPrefix(rel, "cache/") || !strings.Contains(filepath.Base(path), "@") {
+			continue
+		}
+		// directories named like modules inside a module are its content
+		nested := false
+		for _, module := range modules {
+			if isWithin(path, module) {
+				nested = true
+				break
+			}
+		}
+		if nested {
+			continue
+		}
+		modules = append(modules, path)
+
+		escaped, version, _ := strings.Cut(rel, "@")
+		module, err := unescapeModulePath(escaped)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		result, err := verifyGoModule(envelope, path, module, version, module, version, sum, nil)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// VerifyVendor checks the modules of a vendor directory listed in its
+// modules.txt against go.sum. go mod vendor prunes modules down to the
+// packages a build imports and leaves out their go.mod files, tests and
+// testdata, so a module only verifies if it was copied completely. Other
+// modules are reported as pruned rather than as mismatches, as their hash
+// cannot match. Modules replaced by local directories have no go.sum entry
+// and are reported as unknown.
+func VerifyVendor(envelope *Envelope, vendorDir string, modulesTxt io.Reader, sum *GoSum) ([]GoModuleResult, error) {
+	type vendored struct {
+		module, version, sumModule, sumVersion string
+	}
+	var modules []vendored
+	scanner := bufio.NewScanner(modulesTxt)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "# ") {
+			continue
+		}
+		original, replacement, replaced := strings.Cut(strings.TrimPrefix(line, "# "), "=>")
+		fields := strings.Fields(original)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid modules.txt line %q", line)
+		}
+		m := vendored{module: fields[0]}
+		if len(fields) == 2 {
+			m.version = fields[1]
+		}
+		m.sumModule, m.sumVersion = m.module, m.version
+		if replaced {
+			fields := strings.Fields(replacement)
+			if len(fields) == 0 || len(fields) > 2 {
+				return nil, fmt.Errorf("invalid modules.txt line %q", line)
+			}
+			m.sumModule, m.sumVersion = fields[0], ""
+			if len(fields) == 2 {
+				m.sumVersion = fields[1]
+			}
+		}
+		modules = append(modules, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, m := range modules {
+		dirs = append(dirs, filepath.Join(vendorDir, filepath.FromSlash(m.module)))
+	}
+	var results []GoModuleResult
+	for i, m := range modules {
+		var nested []string
+		for _, dir := range dirs {
+			if dir != dirs[i] && isWithin(dir, dirs[i]) {
+				nested = append(nested, dir)
+			}
+		}
+		result, err := verifyGoModule(envelope, dirs[i], m.module, m.version, m.sumModule, m.sumVersion, sum, nested)
+		if err != nil {
+			return nil, err
+		}
+		if result.Status == GoModuleMismatch {
+			result.Status = GoModulePruned
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func verifyGoModule(envelope *Envelope, path, module, version, sumModule, sumVersion string, sum *GoSum, exclude []string) (GoModuleResult, error) {
+	result := GoModuleResult{Module: module, Version: version, Path: path}
+	if _, ok := envelope.Mapping[path]; !ok {
+		result.Status = GoModuleMissing
+		return result, nil
+	}
+	expected, ok := sum.Lookup(sumModule, sumVersion, false)
+	if sumVersion == "" || !ok {
+		result.Status = GoModuleUnknown
+		return result, nil
+	}
+	actual, err := dirHash1(envelope, path, sumModule+"@"+sumVersion, exclude)
+	if err != nil {
+		return result, err
+	}
+	result.Expected, result.Actual = expected, actual
+	result.Status = GoModuleMismatch
+	if expected == actual {
+		result.Status = GoModuleVerified
+	}
+	return result, nil
+}
+
+// unescapeModulePath reverses the case encoding of the module cache, where
+// an upper case letter is written as "!" and its lower case form.
+func unescapeModulePath(escaped string) (string, error) {
+	var b strings.Builder
+	bang := false
+	for _, r := range escaped {
+		switch {
+		case bang:
+			if r < 'a' || r > 'z' {
+				return "", fmt.Errorf("invalid escaped module path %q", escaped)
+			}
+			b.WriteRune(r - 'a' + 'A')
+			bang = false
+		case r == '!':
+			bang = true
+		case r >= 'A' && r <= 'Z':
+			return "", fmt.Errorf("invalid escaped module path %q", escaped)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if bang {
+		return "", fmt.Errorf("invalid escaped module path %q", escaped)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,164 @@
+package omnitrail
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const gitoidModule = "github.com/edwarnicke/gitoid@v0.0.0-20220710194850-1be5bfda1f9d"
+
+// gitoidModuleDir returns a dependency of this module from the module cache,
+// so the hashes can be checked against the go.sum of this repository.
+func gitoidModuleDir(t *testing.T) (string, string) {
+	out, err := exec.Command("go", "env", "GOMODCACHE").Output()
+	if err != nil {
+		t.Skipf("go env: %v", err)
+	}
+	cache := strings.TrimSpace(string(out))
+	dir := filepath.Join(cache, filepath.FromSlash(gitoidModule))
+	if _, err := os.Stat(dir); err != nil {
+		t.Skipf("%s is not in the module cache", gitoidModule)
+	}
+	return cache, dir
+}
+
+func loadGoSum(t *testing.T) *GoSum {
+	f, err := os.Open("go.sum")
+	require.NoError(t, err)
+	defer f.Close()
+	sum, err := ParseGoSum(f)
+	require.NoError(t, err)
+	return sum
+}
+
+func TestDirHash1(t *testing.T) {
+	_, dir := gitoidModuleDir(t)
+	trail := NewTrail()
+	require.NoError(t, trail.Add(dir))
+
+	sum := loadGoSum(t)
+	expected, ok := sum.Lookup("github.com/edwarnicke/gitoid", "v0.0.0-20220710194850-1be5bfda1f9d", false)
+	require.True(t, ok)
+	assert.Equal(t, "h1:4l+Uq5zFWSagXgGFaKRRVWJrnlzeathyagWgYUltCgY=", expected)
+
+	actual, err := DirHash1(trail.Envelope(), dir, gitoidModule)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// as a directory algorithm, the module path is read from go.mod
+	trail = NewTrail(WithDirectoryAlgorithms("h1"))
+	require.NoError(t, trail.Add(dir))
+	envelope := trail.Envelope()
+	assert.Contains(t, envelope.Header.Features["directory"].Algorithms, "h1")
+	assert.Equal(t, expected, envelope.Mapping[dir].H1)
+	assert.Empty(t, envelope.Mapping[filepath.Join(dir, "testdata")].H1)
+
+	goMod, ok := sum.Lookup("github.com/edwarnicke/gitoid", "v0.0.0-20220710194850-1be5bfda1f9d", true)
+	require.True(t, ok)
+	assert.Equal(t, "h1:WxWwA3EYuCQjlR5EBUX3uaTS8bh9BOa7BcqVREHQ0uQ=", goMod)
+}
+
+func TestVerifyModuleCache(t *testing.T) {
+	cache, dir := gitoidModuleDir(t)
+	trail := NewTrail()
+	require.NoError(t, trail.Add(filepath.Dir(dir)))
+	envelope := trail.Envelope()
+	sum := loadGoSum(t)
+
+	results, err := VerifyModuleCache(envelope, cache, sum)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "github.com/edwarnicke/gitoid", results[0].Module)
+	assert.Equal(t, GoModuleVerified, results[0].Status)
+
+	envelope.Mapping[filepath.Join(dir, "gitoid.go")].Sha256 = strings.Repeat("0", 64)
+	results, err = VerifyModuleCache(envelope, cache, sum)
+	require.NoError(t, err)
+	assert.Equal(t, GoModuleMismatch, results[0].Status)
+	assert.Equal(t, "h1:4l+Uq5zFWSagXgGFaKRRVWJrnlzeathyagWgYUltCgY=", results[0].Expected)
+	assert.NotEqual(t, results[0].Expected, results[0].Actual)
+}
+
+func TestVerifyVendor(t *testing.T) {
+	_, dir := gitoidModuleDir(t)
+	vendor := filepath.Join(t.TempDir(), "vendor")
+	vendored := filepath.Join(vendor, "github.com", "edwarnicke", "gitoid")
+	require.NoError(t, filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(vendored, strings.TrimPrefix(path, dir))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, b, 0644)
+	}))
+	// a nested module is not part of the hash of its parent
+	require.NoError(t, os.MkdirAll(filepath.Join(vendored, "nested"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(vendored, "nested", "nested.go"), []byte("package nested\n"), 0644))
+	modulesTxt := "# github.com/edwarnicke/gitoid v0.0.0-20220710194850-1be5bfda1f9d\n" +
+		"## explicit; go 1.18\n" +
+		"github.com/edwarnicke/gitoid\n" +
+		"# github.com/edwarnicke/gitoid/nested v1.0.0\n" +
+		"# example.com/local => ../local\n" +
+		"# example.com/missing v1.0.0\n"
+
+	trail := NewTrail()
+	require.NoError(t, trail.Add(vendor))
+	vendor, err := filepath.Abs(vendor)
+	require.NoError(t, err)
+	results, err := VerifyVendor(trail.Envelope(), vendor, strings.NewReader(modulesTxt), loadGoSum(t))
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	assert.Equal(t, GoModuleVerified, results[0].Status)
+	assert.Equal(t, GoModuleUnknown, results[1].Status)
+	assert.Equal(t, "example.com/local", results[2].Module)
+	assert.Equal(t, GoModuleMissing, results[2].Status)
+	assert.Equal(t, GoModuleMissing, results[3].Status)
+
+	_, err = VerifyVendor(trail.Envelope(), vendor, strings.NewReader("# a b c\n"), loadGoSum(t))
+	assert.Error(t, err)
+
+	// go mod vendor leaves out go.mod files and tests
+	require.NoError(t, os.Remove(filepath.Join(vendored, "go.mod")))
+	require.NoError(t, os.Remove(filepath.Join(vendored, "gitoid_test.go")))
+	trail = NewTrail()
+	require.NoError(t, trail.Add(vendor))
+	results, err = VerifyVendor(trail.Envelope(), vendor, strings.NewReader(modulesTxt), loadGoSum(t))
+	require.NoError(t, err)
+	assert.Equal(t, GoModulePruned, results[0].Status)
+	assert.NotEqual(t, results[0].Expected, results[0].Actual)
+}
+
+func TestParseGoSum(t *testing.T) {
+	sum, err := ParseGoSum(strings.NewReader("example.com/a v1.0.0 h1:abc=\n\nexample.com/a v1.0.0/go.mod h1:def=\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []GoSumEntry{
+		{Module: "example.com/a", Version: "v1.0.0", Hash: "h1:abc="},
+		{Module: "example.com/a", Version: "v1.0.0", GoMod: true, Hash: "h1:def="},
+	}, sum.Entries)
+
+	_, err = ParseGoSum(strings.NewReader("example.com/a v1.0.0\n"))
+	assert.Error(t, err)
+}
+
+func TestUnescapeModulePath(t *testing.T) {
+	path, err := unescapeModulePath("github.com/!burnt!sushi/toml")
+	require.NoError(t, err)
+	assert.Equal(t, "github.com/BurntSushi/toml", path)
+	for _, invalid := range []string{"github.com/Burnt", "a!", "a!1"} {
+		_, err := unescapeModulePath(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
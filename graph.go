@@ -0,0 +1,318 @@
+package omnitrail
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// GraphNode is an artifact of an ADG graph, identified by its gitoid.
+// Manifest is the gitoid of the ADG listing the children of the artifact:
+// the gitoid itself for directories, the derivation ADG for built files or
+// the bom of the reference that led to the artifact.
+type GraphNode struct {
+	Gitoid   string
+	Paths    []string
+	Element  *Element
+	Manifest string
+	Children []string
+	// Missing is set if the ADG of the node could not be resolved
+	Missing bool
+}
+
+// Unresolved reports whether the artifact has no path in the envelope.
+func (n *GraphNode) Unresolved() bool {
+	return len(n.Paths) == 0
+}
+
+// Graph is the artifact dependency graph reachable from a root gitoid.
+type Graph struct {
+	Root  string
+	Nodes map[string]*GraphNode
+	// Missing lists the ADGs that are referenced but could not be resolved
+	Missing []string
+	// Unresolved lists the artifacts that have no path in the envelope
+	Unresolved []string
+	// Cycles lists every cycle as the gitoids along it, starting and ending
+	// with the same gitoid
+	Cycles [][]string
+}
+
+// ResolveGraph walks the ADGs reachable from root, a sha1 or sha256 gitoid
+// of a directory, of a built file or of an ADG. References are resolved to
+// paths and elements through the envelope, and ADGs through the resolver,
+// for example an ADGSet or an OmniBORStore. ADGs that cannot be found are
+// reported rather than failing the walk.
+func ResolveGraph(envelope *Envelope, resolver ADGResolver, root string) (*Graph, error) {
+	if i := strings.LastIndex(root, ":"); i >= 0 {
+		root = root[i+1:]
+	}
+	algorithm := ""
+	switch len(root) {
+	case 40:
+		algorithm = "gitoid:sha1"
+	case 64:
+		algorithm = "gitoid:sha256"
+	}
+	if algorithm == "" || checkHex(root, len(root)) != nil {
+		return nil, fmt.Errorf("invalid gitoid %q", root)
+	}
+
+	paths := make(map[string][]string)
+	manifests := make(map[string]string)
+	for _, path := range sortedKeys(envelope.Mapping) {
+		element := envelope.Mapping[path]
+		gitoid := elementGitoid(element, algorithm)
+		if gitoid == "" {
+			continue
+		}
+		paths[gitoid] = append(paths[gitoid], path)
+		if manifest := inputManifest(element, algorithm); manifest != "" {
+			manifests[gitoid] = manifest
+		}
+	}
+	if _, ok := paths[root]; !ok {
+		// the root is an ADG that is not part of the envelope
+		manifests[root] = root
+	}
+
+	g := &Graph{Root: root, Nodes: make(map[string]*GraphNode)}
+	queue := []string{root}
+	boms := make(map[string]string)
+	for len(queue) > 0 {
+		gitoid := queue[0]
+		queue = queue[1:]
+		if _, ok := g.Nodes[gitoid]; ok {
+			continue
+		}
+		node := &GraphNode{
+			Gitoid:   gitoid,
+			Paths:    paths[gitoid],
+			Manifest: manifests[gitoid],
+		}
+		if len(node.Paths) > 0 {
+			node.Element = envelope.Mapping[node.Paths[0]]
+		} else if gitoid != root {
+			g.Unresolved = append(g.Unresolved, gitoid)
+		}
+		if node.Manifest == "" {
+			node.Manifest = boms[gitoid]
+		}
+		g.Nodes[gitoid] = node
+		if node.Manifest == "" {
+			continue
+		}
+
+		adg, err := resolver.ResolveADG(node.Manifest)
+		if errors.Is(err, ErrADGNotFound) {
+			node.Missing = true
+			g.Missing = appendMissing(g.Missing, node.Manifest)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(adg, "\n"), "\n") {
+			if line == "" {
+				continue
+			}
+			if err := checkReference(line, len(root)); err != nil {
+				return nil, fmt.Errorf("ADG %s: %w", node.Manifest, err)
+			}
+			fields := strings.Split(line, " ")
+			child := fields[1]
+			if len(fields) == 4 && boms[child] == "" {
+				boms[child] = fields[3]
+			}
+			node.Children = append(node.Children, child)
+			queue = append(queue, child)
+		}
+	}
+	sort.Strings(g.Missing)
+	sort.Strings(g.Unresolved)
+	g.Cycles = g.findCycles()
+	return g, nil
+}
+
+// findCycles runs a depth-first search and records every back edge.
+func (g *Graph) findCycles() [][]string {
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := make(map[string]int)
+	var stack []string
+	var cycles [][]string
+	var visit func(gitoid string)
+	visit = func(gitoid string) {
+		state[gitoid] = active
+		stack = append(stack, gitoid)
+		for _, child := range g.Nodes[gitoid].Children {
+			switch state[child] {
+			case unvisited:
+				visit(child)
+			case active:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == child {
+						cycle := append(append([]string{}, stack[i:]...), child)
+						cycles = append(cycles, cycle)
+						break
+					}
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[gitoid] = done
+	}
+	visit(g.Root)
+	return cycles
+}
+
+// DepthFirst calls fn for every node reachable from the root in pre-order,
+// visiting every node once. Children are skipped if fn returns false.
+func (g *Graph) DepthFirst(fn func(node *GraphNode, depth int) bool) {
+	seen := make(map[string]bool)
+	var visit func(gitoid string, depth int)
+	visit = func(gitoid string, depth int) {
+		if seen[gitoid] {
+			return
+		}
+		seen[gitoid] = true
+		node := g.Nodes[gitoid]
+		if !fn(node, depth) {
+			return
+		}
+		for _, child := range node.Children {
+			visit(child, depth+1)
+		}
+	}
+	visit(g.Root, 0)
+}
+
+// BreadthFirst calls fn for every node reachable from the root in order of
+// their distance to the root, visiting every node once. Children are
+// skipped if fn returns false.
+func (g *Graph) BreadthFirst(fn func(node *GraphNode, depth int) bool) {
+	type entry struct {
+		gitoid string
+		depth  int
+	}
+	seen := map[string]bool{g.Root: true}
+	queue := []entry{{g.Root, 0}}
+	for len(queue) > 0 {
+		e := queue[0]
+		queue = queue[1:]
+		node := g.Nodes[e.gitoid]
+		if !fn(node, e.depth) {
+			continue
+		}
+		for _, child := range node.Children {
+			if !seen[child] {
+				seen[child] = true
+				queue = append(queue, entry{child, e.depth + 1})
+			}
+		}
+	}
+}
+
+func (n *GraphNode) label() string {
+	if len(n.Paths) == 0 {
+		return n.Gitoid
+	}
+	return filepath.Base(n.Paths[0])
+}
+
+// Tree renders the graph as an indented tree. Artifacts that were already
+// printed are marked as such instead of being expanded again.
+func (g *Graph) Tree() string {
+	var b strings.Builder
+	printed := make(map[string]bool)
+	var visit func(gitoid string, depth int, ancestors map[string]bool)
+	visit = func(gitoid string, depth int, ancestors map[string]bool) {
+		node := g.Nodes[gitoid]
+		var notes []string
+		if node.Element != nil {
+			notes = append(notes, node.Element.Type)
+		}
+		if node.Unresolved() && gitoid != g.Root {
+			notes = append(notes, "unresolved")
+		}
+		if node.Missing {
+			notes = append(notes, "missing ADG "+node.Manifest)
+		}
+		expand := true
+		switch {
+		case ancestors[gitoid]:
+			notes = append(notes, "cycle")
+			expand = false
+		case printed[gitoid] && len(node.Children) > 0:
+			notes = append(notes, "see above")
+			expand = false
+		}
+		b.WriteString(strings.Repeat("  ", depth))
+		if !node.Unresolved() {
+			fmt.Fprintf(&b, "%s ", node.label())
+		}
+		b.WriteString(gitoid)
+		if len(notes) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(notes, ", "))
+		}
+		b.WriteString("\n")
+		if !expand {
+			return
+		}
+		printed[gitoid] = true
+		ancestors[gitoid] = true
+		for _, child := range g.sortedChildren(node) {
+			visit(child, depth+1, ancestors)
+		}
+		delete(ancestors, gitoid)
+	}
+	visit(g.Root, 0, make(map[string]bool))
+	return b.String()
+}
+
+// sortedChildren orders children by label so that trees read like a
+// directory listing.
+func (g *Graph) sortedChildren(node *GraphNode) []string {
+	children := append([]string{}, node.Children...)
+	sort.SliceStable(children, func(i, j int) bool {
+		return g.Nodes[children[i]].label() < g.Nodes[children[j]].label()
+	})
+	return children
+}
+
+// DOT renders the graph in the Graphviz DOT language. Unresolved artifacts
+// and artifacts with a missing ADG are drawn dashed.
+func (g *Graph) DOT() string {
+	gitoids := make([]string, 0, len(g.Nodes))
+	for gitoid := range g.Nodes {
+		gitoids = append(gitoids, gitoid)
+	}
+	sort.Strings(gitoids)
+
+	var b strings.Builder
+	b.WriteString("digraph omnitrail {\n")
+	for _, gitoid := range gitoids {
+		node := g.Nodes[gitoid]
+		label := gitoid[:12]
+		if !node.Unresolved() {
+			label = node.label() + "\n" + label
+		}
+		attributes := fmt.Sprintf("label=%q", label)
+		if node.Missing || (node.Unresolved() && gitoid != g.Root) {
+			attributes += ", style=dashed"
+		}
+		fmt.Fprintf(&b, "  %q [%s];\n", gitoid, attributes)
+	}
+	for _, gitoid := range gitoids {
+		for _, child := range g.Nodes[gitoid].Children {
+			fmt.Fprintf(&b, "  %q -> %q;\n", gitoid, child)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
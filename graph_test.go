@@ -0,0 +1,122 @@
+package omnitrail
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadADGs(t *testing.T, name string) *ADGSet {
+	b, err := os.ReadFile("./test/" + name + ".adg")
+	require.NoError(t, err)
+	adgs, err := ParseADGString(string(b))
+	require.NoError(t, err)
+	return adgs
+}
+
+const deepRoot = "3f8d7b5b500f4db43c40c4586f754e4702c1a7a3d61509867f1af37fae04fa18"
+
+func TestResolveGraph(t *testing.T) {
+	envelope := rebase(loadEnvelope(t, "deep"), "/deep")
+	g, err := ResolveGraph(envelope, loadADGs(t, "deep"), "gitoid:blob:sha256:"+deepRoot)
+	require.NoError(t, err)
+	assert.Equal(t, deepRoot, g.Root)
+	assert.Len(t, g.Nodes, 6)
+	assert.Empty(t, g.Missing)
+	assert.Empty(t, g.Unresolved)
+	assert.Empty(t, g.Cycles)
+
+	root := g.Nodes[deepRoot]
+	assert.Equal(t, []string{"/deep"}, root.Paths)
+	assert.Equal(t, deepRoot, root.Manifest)
+	assert.Len(t, root.Children, 2)
+
+	var dfs []string
+	g.DepthFirst(func(node *GraphNode, depth int) bool {
+		dfs = append(dfs, strings.Repeat(" ", depth)+node.Paths[0])
+		return true
+	})
+	assert.Len(t, dfs, 6)
+	assert.Equal(t, "/deep", dfs[0])
+	assert.Contains(t, dfs, "   /deep/dir1/dir2/file2.txt")
+
+	depths := make(map[string]int)
+	var bfs []string
+	g.BreadthFirst(func(node *GraphNode, depth int) bool {
+		depths[node.Paths[0]] = depth
+		bfs = append(bfs, node.Paths[0])
+		return node.Paths[0] != "/deep/dir1/dir2"
+	})
+	assert.Equal(t, map[string]int{
+		"/deep":                0,
+		"/deep/root.txt":       1,
+		"/deep/dir1":           1,
+		"/deep/dir1/file1.txt": 2,
+		"/deep/dir1/dir2":      2,
+	}, depths)
+	assert.Equal(t, "/deep/dir1/dir2", bfs[len(bfs)-1])
+
+	assert.Equal(t, "deep "+deepRoot+" (directory)\n"+
+		"  dir1 188f8b3bc7cbce45e6bb5c2063c9733eb3a18ddcd3f593556b9a8626961b0f77 (directory)\n"+
+		"    dir2 4dc2f9fa74bb7761434f4994c26b52d1b8c27661f19dba63d225749b5a5a60f3 (directory)\n"+
+		"      file2.txt 73bae245b03a7c9d540831beca1987b2a34f8bec948d032132d09224b613d186 (file)\n"+
+		"    file1.txt 2da00b9c51e5e7554c44a3929f9f8cee3c4e742d4f71bec0d4e1d37256d17207 (file)\n"+
+		"  root.txt 520f6e3c5ab9d63a4b3acd9bb69d90aad62dd0362d35355d47fcda9f1b70be3e (file)\n",
+		g.Tree())
+
+	dot := g.DOT()
+	assert.True(t, strings.HasPrefix(dot, "digraph omnitrail {\n"))
+	assert.Contains(t, dot, "\""+deepRoot+"\" [label=\"deep\\n3f8d7b5b500f\"];\n")
+	assert.Contains(t, dot, "\""+deepRoot+"\" -> \"188f8b3bc7cbce45e6bb5c2063c9733eb3a18ddcd3f593556b9a8626961b0f77\";\n")
+}
+
+func TestResolveGraphMissing(t *testing.T) {
+	envelope := rebase(loadEnvelope(t, "deep"), "/deep")
+	delete(envelope.Mapping, "/deep/root.txt")
+	adgs := loadADGs(t, "deep")
+	dir2 := "4dc2f9fa74bb7761434f4994c26b52d1b8c27661f19dba63d225749b5a5a60f3"
+	delete(adgs.Sha256, dir2)
+
+	g, err := ResolveGraph(envelope, adgs, deepRoot)
+	require.NoError(t, err)
+	assert.Equal(t, []string{dir2}, g.Missing)
+	assert.True(t, g.Nodes[dir2].Missing)
+	assert.Equal(t, []string{"520f6e3c5ab9d63a4b3acd9bb69d90aad62dd0362d35355d47fcda9f1b70be3e"}, g.Unresolved)
+	assert.Contains(t, g.Tree(), "    dir2 "+dir2+" (directory, missing ADG "+dir2+")\n")
+	assert.Contains(t, g.Tree(), "  520f6e3c5ab9d63a4b3acd9bb69d90aad62dd0362d35355d47fcda9f1b70be3e (unresolved)\n")
+	assert.Contains(t, g.DOT(), "[label=\"520f6e3c5ab9\", style=dashed]")
+
+	_, err = ResolveGraph(envelope, adgs, "not a gitoid")
+	assert.Error(t, err)
+}
+
+func TestResolveGraphCycle(t *testing.T) {
+	a := "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	b := "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	adgs := NewADGSet()
+	aADG := adgIdentity("blob "+b+"\n", false)
+	bADG := adgIdentity("blob "+a+"\n", false)
+	adgs.Sha1[aADG] = "blob " + b + "\n"
+	adgs.Sha1[bADG] = "blob " + a + "\n"
+	envelope := &Envelope{Mapping: map[string]*Element{
+		"/out/a": {Type: "file", Sha1Gitoid: a, Derivation: &Derivation{Inputs: []string{"b"}, Sha1Gitoid: aADG}},
+		"/out/b": {Type: "file", Sha1Gitoid: b, Derivation: &Derivation{Inputs: []string{"a"}, Sha1Gitoid: bADG}},
+	}}
+
+	g, err := ResolveGraph(envelope, adgs, a)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{a, b, a}}, g.Cycles)
+	assert.Equal(t, "a "+a+" (file)\n"+
+		"  b "+b+" (file)\n"+
+		"    a "+a+" (file, cycle)\n", g.Tree())
+
+	visited := 0
+	g.DepthFirst(func(node *GraphNode, depth int) bool {
+		visited++
+		return true
+	})
+	assert.Equal(t, 2, visited)
+}
@@ -0,0 +1,330 @@
+package omnitrail
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+func init() {
+	RegisterOptionalPlugin("ima", NewIMAPlugin)
+}
+
+// IMA xattr types of the kernel's enum evm_ima_xattr_type
+const (
+	imaXattrDigest         = 0x01
+	evmXattrHMAC           = 0x02
+	evmImaXattrDigsig      = 0x03
+	imaXattrDigestNG       = 0x04
+	evmXattrPortableDigsig = 0x05
+	imaVerityDigsig        = 0x06
+)
+
+// imaHashAlgorithms maps the kernel's enum hash_algo to the digest names of
+// this package.
+var imaHashAlgorithms = map[byte]string{
+	0:  "md4",
+	1:  "md5",
+	2:  "sha1",
+	3:  "rmd160",
+	4:  "sha256",
+	5:  "sha384",
+	6:  "sha512",
+	7:  "sha224",
+	8:  "rmd128",
+	9:  "rmd256",
+	10: "rmd320",
+	11: "wp256",
+	12: "wp384",
+	13: "wp512",
+	14: "tgr128",
+	15: "tgr160",
+	16: "tgr192",
+	17: "sm3-256",
+	18: "streebog256",
+	19: "streebog512",
+	20: "sha3-256",
+	21: "sha3-384",
+	22: "sha3-512",
+}
+
+// imaDigestSizes are the digest sizes in bytes of the IMA hash algorithms.
+var imaDigestSizes = map[string]int{
+	"md4":         16,
+	"md5":         16,
+	"sha1":        20,
+	"rmd160":      20,
+	"sha256":      32,
+	"sha384":      48,
+	"sha512":      64,
+	"sha224":      28,
+	"rmd128":      16,
+	"rmd256":      32,
+	"rmd320":      40,
+	"wp256":       32,
+	"wp384":       48,
+	"wp512":       64,
+	"tgr128":      16,
+	"tgr160":      20,
+	"tgr192":      24,
+	"sm3-256":     32,
+	"streebog256": 32,
+	"streebog512": 64,
+	"sha3-256":    32,
+	"sha3-384":    48,
+	"sha3-512":    64,
+}
+
+var (
+	// errIMAInvalid is returned for xattrs that are truncated or malformed.
+	errIMAInvalid = errors.New("invalid xattr")
+	// errIMAUnknown is returned for xattrs of a type, hash algorithm or
+	// signature version this package does not know.
+	errIMAUnknown = errors.New("unknown xattr")
+)
+
+const (
+	// IMAMissing is a file without a security.ima xattr
+	IMAMissing = "missing"
+	// IMAMismatch is a file whose IMA hash differs from its digest
+	IMAMismatch = "mismatch"
+	// IMAUnsigned is a file whose IMA hash matches its digest, but which has
+	// no signature
+	IMAUnsigned = "unsigned"
+	// IMAUnverified is a file with an IMA hash in an algorithm the trail did
+	// not compute
+	IMAUnverified = "unverified"
+	// IMASigned is a file with an IMA signature. Signatures are recorded but
+	// not verified, as that needs the public keys of the IMA keyring.
+	IMASigned = "signed"
+	// IMAInvalid is a file whose security.ima xattr is truncated or
+	// malformed. EVM is set to it for such security.evm xattrs.
+	IMAInvalid = "invalid"
+	// IMAUnknown is a file whose security.ima xattr has a type, hash
+	// algorithm or signature version this package does not know. EVM is set
+	// to it for such security.evm xattrs.
+	IMAUnknown = "unknown"
+)
+
+// IMA describes the security.ima and security.evm xattrs of a file, as used
+// by Linux IMA appraisal and EVM. Binary values are hex encoded.
+type IMA struct {
+	// Format is "digest", "digest-ng", "signature" or "verity-signature"
+	Format           string `json:"format,omitempty"`
+	Algorithm        string `json:"algorithm,omitempty"`
+	Digest           string `json:"digest,omitempty"`
+	SignatureVersion string `json:"signature_version,omitempty"`
+	KeyID            string `json:"key_id,omitempty"`
+	Signature        string `json:"signature,omitempty"`
+	// Value is the security.ima xattr of files with an invalid or unknown
+	// one
+	Value string `json:"value,omitempty"`
+	// EVM is "hmac", "signature", "portable-signature", "invalid" or
+	// "unknown"
+	EVM      string `json:"evm,omitempty"`
+	EVMKeyID string `json:"evm_key_id,omitempty"`
+	EVMValue string `json:"evm_value,omitempty"`
+	Status   string `json:"status"`
+}
+
+// IMAPlugin records the IMA and EVM xattrs of every file, and compares IMA
+// hashes with the digests of the file plugin, so that the appraisal
+// readiness of an image can be audited. Files without a signature have a
+// status other than IMASigned.
+type IMAPlugin struct {
+	files     map[string]*IMA
+	AllowList []string
+}
+
+func NewIMAPlugin() Plugin {
+	return &IMAPlugin{
+		files: make(map[string]*IMA),
+	}
+}
+
+func (plug *IMAPlugin) Add(path string) error {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		// broken symlinks are ignored, as in the file plugin
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return nil
+	}
+
+	ima := &IMA{Status: IMAMissing}
+	value, ok, err := getXattr(path, "security.ima")
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := ima.decodeIMA(value); err != nil {
+			*ima = IMA{Status: imaErrorStatus(err), Value: hex.EncodeToString(value)}
+		}
+	}
+	value, ok, err = getXattr(path, "security.evm")
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := ima.decodeEVM(value); err != nil {
+			ima.EVM = imaErrorStatus(err)
+			ima.EVMKeyID = ""
+			ima.EVMValue = hex.EncodeToString(value)
+		}
+	}
+	plug.files[path] = ima
+	return nil
+}
+
+// Store compares the IMA hashes with the digests, so it has to run after the
+// file plugin stored them.
+func (plug *IMAPlugin) Store(envelope *Envelope) error {
+	envelope.Header.Features["ima"] = Feature{}
+	for path, ima := range plug.files {
+		element, ok := envelope.Mapping[path]
+		if !ok {
+			continue
+		}
+		if ima.Format == "digest" || ima.Format == "digest-ng" {
+			digest, ok := elementDigests(element)[ima.Algorithm]
+			switch {
+			case !ok:
+				ima.Status = IMAUnverified
+			case digest == ima.Digest:
+				ima.Status = IMAUnsigned
+			default:
+				ima.Status = IMAMismatch
+			}
+		}
+		element.IMA = ima
+	}
+	return nil
+}
+
+// Algorithms returns no algorithms. The IMA plugin records the hashes files
+// carry and compares them with the file digests, but computes none itself.
+func (plug *IMAPlugin) Algorithms() []string {
+	return nil
+}
+
+func (plug *IMAPlugin) Sha1ADG(map[string]string) {}
+
+func (plug *IMAPlugin) Sha256ADG(map[string]string) {}
+
+func (plug *IMAPlugin) SetAllowList(allowList []string) {
+	plug.AllowList = allowList
+}
+
+// imaErrorStatus is the status of an xattr that could not be decoded.
+func imaErrorStatus(err error) string {
+	if errors.Is(err, errIMAUnknown) {
+		return IMAUnknown
+	}
+	return IMAInvalid
+}
+
+func imaHashAlgorithm(id byte) (string, error) {
+	algorithm, ok := imaHashAlgorithms[id]
+	if !ok {
+		return "", fmt.Errorf("%w: hash algorithm %d", errIMAUnknown, id)
+	}
+	return algorithm, nil
+}
+
+// decodeIMA decodes the hash or signature of a security.ima xattr.
+func (ima *IMA) decodeIMA(value []byte) error {
+	if len(value) == 0 {
+		return fmt.Errorf("%w: empty", errIMAInvalid)
+	}
+	switch value[0] {
+	case imaXattrDigest:
+		// the original format, always sha1
+		if len(value) != 1+20 {
+			return fmt.Errorf("%w: sha1 digest length %d", errIMAInvalid, len(value)-1)
+		}
+		ima.Format = "digest"
+		ima.Algorithm = "sha1"
+		ima.Digest = hex.EncodeToString(value[1:])
+	case imaXattrDigestNG:
+		if len(value) < 3 {
+			return fmt.Errorf("%w: truncated digest", errIMAInvalid)
+		}
+		algorithm, err := imaHashAlgorithm(value[1])
+		if err != nil {
+			return err
+		}
+		if len(value)-2 != imaDigestSizes[algorithm] {
+			return fmt.Errorf("%w: %s digest length %d", errIMAInvalid, algorithm, len(value)-2)
+		}
+		ima.Format = "digest-ng"
+		ima.Algorithm = algorithm
+		ima.Digest = hex.EncodeToString(value[2:])
+	case evmImaXattrDigsig, imaVerityDigsig:
+		version, algorithm, keyID, signature, err := decodeIMASignature(value)
+		if err != nil {
+			return err
+		}
+		ima.Format = "signature"
+		if value[0] == imaVerityDigsig {
+			ima.Format = "verity-signature"
+		}
+		ima.SignatureVersion = version
+		ima.Algorithm = algorithm
+		ima.KeyID = keyID
+		ima.Signature = signature
+		ima.Status = IMASigned
+	default:
+		return fmt.Errorf("%w: type %d", errIMAUnknown, value[0])
+	}
+	return nil
+}
+
+// decodeEVM decodes the type of a security.evm xattr, and the key of its
+// signature.
+func (ima *IMA) decodeEVM(value []byte) error {
+	if len(value) == 0 {
+		return fmt.Errorf("%w: empty", errIMAInvalid)
+	}
+	switch value[0] {
+	case evmXattrHMAC:
+		ima.EVM = "hmac"
+		return nil
+	case evmImaXattrDigsig:
+		ima.EVM = "signature"
+	case evmXattrPortableDigsig:
+		ima.EVM = "portable-signature"
+	default:
+		return fmt.Errorf("%w: type %d", errIMAUnknown, value[0])
+	}
+	_, _, keyID, _, err := decodeIMASignature(value)
+	ima.EVMKeyID = keyID
+	return err
+}
+
+// decodeIMASignature decodes struct signature_v2_hdr, which is also used by
+// version 3 signatures:
+//
+//	u8 type; u8 version; u8 hash_algo; __be32 keyid; __be16 sig_size; u8 sig[];
+func decodeIMASignature(value []byte) (version, algorithm, keyID, signature string, err error) {
+	if len(value) < 9 {
+		return "", "", "", "", fmt.Errorf("%w: truncated signature header", errIMAInvalid)
+	}
+	if value[1] != 2 && value[1] != 3 {
+		return "", "", "", "", fmt.Errorf("%w: signature version %d", errIMAUnknown, value[1])
+	}
+	algorithm, err = imaHashAlgorithm(value[2])
+	if err != nil {
+		return "", "", "", "", err
+	}
+	size := int(binary.BigEndian.Uint16(value[7:9]))
+	if len(value) != 9+size {
+		return "", "", "", "", fmt.Errorf("%w: signature size %d does not match xattr size %d", errIMAInvalid, size, len(value))
+	}
+	return fmt.Sprint(value[1]), algorithm, hex.EncodeToString(value[3:7]), hex.EncodeToString(value[9:]), nil
+}
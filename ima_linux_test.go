@@ -0,0 +1,73 @@
+//go:build linux
+
+package omnitrail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func setXattr(t *testing.T, path, name, value string) {
+	if err := unix.Setxattr(path, name, mustDecodeHex(t, value), 0); err != nil {
+		t.Skipf("cannot set %s: %v", name, err)
+	}
+}
+
+func TestIMAPlugin(t *testing.T) {
+	sha256 := "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"
+	dir := t.TempDir()
+	write := func(name string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte("hello world\n"), 0644))
+		return path
+	}
+	missing := write("missing")
+	unsigned := write("unsigned")
+	setXattr(t, unsigned, "security.ima", "0404"+sha256)
+	mismatch := write("mismatch")
+	setXattr(t, mismatch, "security.ima", "0404"+sha256[2:]+"00")
+	unverified := write("unverified")
+	setXattr(t, unverified, "security.ima", "0406"+sha256+sha256)
+	signed := write("signed")
+	setXattr(t, signed, "security.ima", "030204a1b2c3d40003010203")
+	setXattr(t, signed, "security.evm", "050204a1b2c3d4000101")
+	invalid := write("invalid")
+	setXattr(t, invalid, "security.ima", "0404"+sha256[2:])
+	setXattr(t, invalid, "security.evm", "0302")
+	unknown := write("unknown")
+	setXattr(t, unknown, "security.ima", "09")
+
+	trail := NewTrail(WithPlugins("ima"))
+	require.NoError(t, trail.Add(dir))
+	envelope := trail.Envelope()
+	for path, status := range map[string]string{
+		missing:    IMAMissing,
+		unsigned:   IMAUnsigned,
+		mismatch:   IMAMismatch,
+		unverified: IMAUnverified,
+		signed:     IMASigned,
+		invalid:    IMAInvalid,
+		unknown:    IMAUnknown,
+	} {
+		assert.Equal(t, status, envelope.Mapping[path].IMA.Status, path)
+	}
+	assert.Equal(t, "portable-signature", envelope.Mapping[signed].IMA.EVM)
+	assert.Equal(t, &IMA{
+		Value:    "0404" + sha256[2:],
+		EVM:      IMAInvalid,
+		EVMValue: "0302",
+		Status:   IMAInvalid,
+	}, envelope.Mapping[invalid].IMA)
+	assert.Equal(t, "09", envelope.Mapping[unknown].IMA.Value)
+	assert.Nil(t, envelope.Mapping[dir].IMA)
+
+	// the sha512 hash is verified once the trail computes sha512
+	trail = NewTrail(WithPlugins("ima"), WithFileAlgorithms("sha512"))
+	require.NoError(t, trail.Add(dir))
+	assert.Equal(t, IMAMismatch, trail.Envelope().Mapping[unverified].IMA.Status)
+}
@@ -0,0 +1,65 @@
+package omnitrail
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeIMA(t *testing.T) {
+	sha1 := "22596363b3de40b06f981fb85d82312e8c0ed511"
+	sha256 := "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"
+	for name, tt := range map[string]struct {
+		value    string
+		expected IMA
+	}{
+		"digest":           {"01" + sha1, IMA{Format: "digest", Algorithm: "sha1", Digest: sha1}},
+		"digest-ng":        {"0404" + sha256, IMA{Format: "digest-ng", Algorithm: "sha256", Digest: sha256}},
+		"signature":        {"030204a1b2c3d40003010203", IMA{Format: "signature", SignatureVersion: "2", Algorithm: "sha256", KeyID: "a1b2c3d4", Signature: "010203", Status: IMASigned}},
+		"verity-signature": {"060306a1b2c3d4000101", IMA{Format: "verity-signature", SignatureVersion: "3", Algorithm: "sha512", KeyID: "a1b2c3d4", Signature: "01", Status: IMASigned}},
+	} {
+		ima := IMA{}
+		assert.NoError(t, ima.decodeIMA(mustDecodeHex(t, tt.value)), name)
+		assert.Equal(t, tt.expected, ima, name)
+	}
+
+	for name, tt := range map[string]struct {
+		value string
+		err   error
+	}{
+		"empty":             {"", errIMAInvalid},
+		"unknown type":      {"09", errIMAUnknown},
+		"short sha1":        {"01abcd", errIMAInvalid},
+		"unknown algorithm": {"04ff" + sha256, errIMAUnknown},
+		"short sha256":      {"0404" + sha1, errIMAInvalid},
+		"long sha1":         {"0402" + sha256, errIMAInvalid},
+		"truncated header":  {"030204a1b2", errIMAInvalid},
+		"bad version":       {"030104a1b2c3d4000101", errIMAUnknown},
+		"bad size":          {"030204a1b2c3d4000501", errIMAInvalid},
+	} {
+		ima := IMA{}
+		assert.ErrorIs(t, ima.decodeIMA(mustDecodeHex(t, tt.value)), tt.err, name)
+	}
+}
+
+func TestDecodeEVM(t *testing.T) {
+	ima := IMA{}
+	assert.NoError(t, ima.decodeEVM(mustDecodeHex(t, "02"+"00112233445566778899aabbccddeeff00112233")))
+	assert.Equal(t, "hmac", ima.EVM)
+
+	ima = IMA{}
+	assert.NoError(t, ima.decodeEVM(mustDecodeHex(t, "050204a1b2c3d4000101")))
+	assert.Equal(t, IMA{EVM: "portable-signature", EVMKeyID: "a1b2c3d4"}, ima)
+
+	assert.ErrorIs(t, ima.decodeEVM(mustDecodeHex(t, "04")), errIMAUnknown)
+	assert.ErrorIs(t, ima.decodeEVM(mustDecodeHex(t, "0502")), errIMAInvalid)
+}
@@ -0,0 +1,214 @@
+package omnitrail
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Index answers queries over the entries of an envelope without ranging over
+// its mapping. The index is not updated when the envelope changes.
+type Index struct {
+	envelope *Envelope
+	paths    []string
+	// digest and algorithm:digest -> paths
+	digests map[string][]string
+}
+
+func NewIndex(envelope *Envelope) *Index {
+	idx := &Index{
+		envelope: envelope,
+		paths:    sortedKeys(envelope.Mapping),
+		digests:  make(map[string][]string),
+	}
+	for _, path := range idx.paths {
+		for algorithm, digest := range elementDigests(envelope.Mapping[path]) {
+			idx.digests[digest] = appendMissing(idx.digests[digest], path)
+			idx.digests[algorithm+":"+digest] = append(idx.digests[algorithm+":"+digest], path)
+		}
+	}
+	return idx
+}
+
+// Iterator walks the results of an index query in lexical order of their
+// paths.
+//
+//	it := idx.ByDigest(digest)
+//	for it.Next() {
+//		fmt.Println(it.Path(), it.Element().Type)
+//	}
+type Iterator struct {
+	mapping map[string]*Element
+	paths   []string
+	pos     int
+}
+
+func (it *Iterator) Next() bool {
+	if it.pos >= len(it.paths) {
+		return false
+	}
+	it.pos++
+	return true
+}
+
+func (it *Iterator) Path() string {
+	return it.paths[it.pos-1]
+}
+
+func (it *Iterator) Element() *Element {
+	return it.mapping[it.Path()]
+}
+
+// Len returns the total number of results of the query.
+func (it *Iterator) Len() int {
+	return len(it.paths)
+}
+
+func (idx *Index) iterator(paths []string) *Iterator {
+	return &Iterator{mapping: idx.envelope.Mapping, paths: paths}
+}
+
+// All returns every entry of the envelope.
+func (idx *Index) All() *Iterator {
+	return idx.iterator(idx.paths)
+}
+
+// Filter returns the entries for which fn returns true.
+func (idx *Index) Filter(fn func(path string, element *Element) bool) *Iterator {
+	var paths []string
+	for _, path := range idx.paths {
+		if fn(path, idx.envelope.Mapping[path]) {
+			paths = append(paths, path)
+		}
+	}
+	return idx.iterator(paths)
+}
+
+// ByDigest returns the entries with the given content. The digest is either
+// a bare hex digest, prefixed by its algorithm such as "sha256:<hex>" or
+// "gitoid:sha1:<hex>", or a gitoid URI such as "gitoid:blob:sha256:<hex>".
+func (idx *Index) ByDigest(digest string) *Iterator {
+	return idx.iterator(idx.digests[normalizeDigest(digest)])
+}
+
+// BySWHID returns the entries with the given Software Heritage identifier.
+// Qualifiers are ignored. Without the swhid feature, contents are found by
+// their sha1 gitoid, which has the same value.
+func (idx *Index) BySWHID(swhid string) (*Iterator, error) {
+	id, err := ParseSWHID(swhid)
+	if err != nil {
+		return nil, err
+	}
+	if paths := idx.digests["swhid:"+id.Core()]; len(paths) > 0 || id.ObjectType != "cnt" {
+		return idx.iterator(paths), nil
+	}
+	return idx.iterator(idx.digests["gitoid:sha1:"+id.Hash]), nil
+}
+
+// Containing returns the directories that transitively contain an entry with
+// the given content.
+func (idx *Index) Containing(digest string) *Iterator {
+	seen := make(map[string]bool)
+	for _, path := range idx.digests[normalizeDigest(digest)] {
+		for child, dir := path, filepath.Dir(path); dir != child; child, dir = dir, filepath.Dir(dir) {
+			element, ok := idx.envelope.Mapping[dir]
+			if !ok {
+				break
+			}
+			if element.Type == "directory" {
+				seen[dir] = true
+			}
+		}
+	}
+	return idx.iterator(sortedSet(seen))
+}
+
+// ByPrefix returns the entry at path and every entry below it. A relative
+// path is resolved against the root of the envelope.
+func (idx *Index) ByPrefix(path string) *Iterator {
+	if _, ok := idx.envelope.Mapping[path]; !ok && !filepath.IsAbs(path) {
+		if root := envelopeRoot(idx.envelope.Mapping); root != "" {
+			path = filepath.Join(root, filepath.FromSlash(path))
+		}
+	}
+	return idx.Filter(func(key string, _ *Element) bool {
+		return isWithin(key, path)
+	})
+}
+
+// ByType returns the entries of the given type, such as "file" or "directory".
+func (idx *Index) ByType(elementType string) *Iterator {
+	return idx.Filter(func(_ string, element *Element) bool {
+		return element.Type == elementType
+	})
+}
+
+// PosixAttribute is a property of an entry derived from its POSIX permissions.
+type PosixAttribute int
+
+const (
+	PosixSetuid PosixAttribute = iota
+	PosixSetgid
+	PosixSticky
+	PosixWorldWritable
+	PosixExecutable
+)
+
+// ByPosix returns the entries whose recorded permissions have the attribute.
+func (idx *Index) ByPosix(attribute PosixAttribute) *Iterator {
+	return idx.Filter(func(_ string, element *Element) bool {
+		return element.Posix != nil && hasPosixAttribute(element.Posix.Permissions, attribute)
+	})
+}
+
+// ByCapability returns the entries with a file capability in any of their
+// capability sets, such as "cap_net_raw", or with any file capability if
+// capability is empty.
+func (idx *Index) ByCapability(capability string) *Iterator {
+	return idx.Filter(func(_ string, element *Element) bool {
+		c := element.Capabilities
+		if c == nil {
+			return false
+		}
+		if capability == "" {
+			return true
+		}
+		for _, set := range [][]string{c.Permitted, c.Inheritable, c.Effective} {
+			for _, name := range set {
+				if name == capability {
+					return true
+				}
+			}
+		}
+		return false
+	})
+}
+
+// hasPosixAttribute checks a permission string in the format of
+// os.FileMode.String, for example "urwxr-xr-x" for a setuid executable.
+func hasPosixAttribute(permissions string, attribute PosixAttribute) bool {
+	if len(permissions) < 9 {
+		return false
+	}
+	flags := permissions[:len(permissions)-9]
+	bits := permissions[len(permissions)-9:]
+	switch attribute {
+	case PosixSetuid:
+		return strings.ContainsRune(flags, 'u')
+	case PosixSetgid:
+		return strings.ContainsRune(flags, 'g')
+	case PosixSticky:
+		return strings.ContainsRune(flags, 't')
+	case PosixWorldWritable:
+		return bits[7] == 'w'
+	case PosixExecutable:
+		return bits[2] == 'x' || bits[5] == 'x' || bits[8] == 'x'
+	}
+	return false
+}
+
+func normalizeDigest(digest string) string {
+	if strings.HasPrefix(digest, "gitoid:blob:") {
+		return "gitoid:" + strings.TrimPrefix(digest, "gitoid:blob:")
+	}
+	return digest
+}
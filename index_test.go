@@ -0,0 +1,46 @@
+package omnitrail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collect(it *Iterator) []string {
+	var paths []string
+	for it.Next() {
+		paths = append(paths, it.Path())
+	}
+	return paths
+}
+
+func TestIndexDigests(t *testing.T) {
+	deep := rebase(loadEnvelope(t, "deep"), "/deep")
+	deep.Mapping["/deep/copy.txt"] = deep.Mapping["/deep/dir1/dir2/file2.txt"].clone()
+	idx := NewIndex(deep)
+
+	file2 := []string{"/deep/copy.txt", "/deep/dir1/dir2/file2.txt"}
+	assert.Equal(t, file2, collect(idx.ByDigest("3377870dfeaaa7adf79a374d2702a3fdb13e5e5ea0dd8aa95a802ad39044a92f")))
+	assert.Equal(t, file2, collect(idx.ByDigest("sha256:3377870dfeaaa7adf79a374d2702a3fdb13e5e5ea0dd8aa95a802ad39044a92f")))
+	assert.Equal(t, file2, collect(idx.ByDigest("gitoid:blob:sha1:30d67d4672d5c05833b7192cc77a79eaafb5c7ad")))
+	assert.Empty(t, collect(idx.ByDigest("sha1:3377870dfeaaa7adf79a374d2702a3fdb13e5e5ea0dd8aa95a802ad39044a92f")))
+
+	// directory gitoids are content identities too
+	assert.Equal(t, []string{"/deep/dir1/dir2"}, collect(idx.ByDigest("b51452d06f9e8b948089762da313b43973cbd6a6")))
+
+	assert.Equal(t, []string{"/deep", "/deep/dir1", "/deep/dir1/dir2"}, collect(idx.Containing("30d67d4672d5c05833b7192cc77a79eaafb5c7ad")))
+}
+
+func TestIndexQueries(t *testing.T) {
+	deep := rebase(loadEnvelope(t, "deep"), "/deep")
+	deep.Mapping["/deep/root.txt"].Posix.Permissions = "urwxr-xrwx"
+	idx := NewIndex(deep)
+
+	assert.Equal(t, []string{"/deep/dir1/dir2", "/deep/dir1/dir2/file2.txt"}, collect(idx.ByPrefix("dir1/dir2")))
+	assert.Equal(t, []string{"/deep/dir1/dir2", "/deep/dir1/dir2/file2.txt"}, collect(idx.ByPrefix("/deep/dir1/dir2")))
+	assert.Equal(t, []string{"/deep", "/deep/dir1", "/deep/dir1/dir2"}, collect(idx.ByType("directory")))
+	assert.Equal(t, []string{"/deep/root.txt"}, collect(idx.ByPosix(PosixSetuid)))
+	assert.Equal(t, []string{"/deep/root.txt"}, collect(idx.ByPosix(PosixWorldWritable)))
+	assert.Empty(t, collect(idx.ByPosix(PosixSticky)))
+	assert.Equal(t, 6, idx.All().Len())
+}
@@ -0,0 +1,204 @@
+package omnitrail
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// InputManifest is an OmniBOR Input Manifest. It starts with a
+// "gitoid:blob:<algorithm>" header line followed by one line per input
+// artifact in ascending order: the hex gitoid of the input, optionally
+// followed by " manifest " and the identifier of the input's own manifest.
+type InputManifest struct {
+	Algorithm string          `json:"algorithm"`
+	Inputs    []ManifestInput `json:"inputs"`
+}
+
+type ManifestInput struct {
+	Artifact string `json:"artifact"`
+	Manifest string `json:"manifest,omitempty"`
+}
+
+// NewInputManifest returns an empty manifest for the "sha1" or "sha256"
+// gitoid variant.
+func NewInputManifest(algorithm string) (*InputManifest, error) {
+	if _, err := manifestHashLength(algorithm); err != nil {
+		return nil, err
+	}
+	return &InputManifest{Algorithm: algorithm, Inputs: []ManifestInput{}}, nil
+}
+
+func manifestHashLength(algorithm string) (int, error) {
+	switch algorithm {
+	case "sha1":
+		return 40, nil
+	case "sha256":
+		return 64, nil
+	}
+	return 0, fmt.Errorf("unsupported input manifest algorithm %s", algorithm)
+}
+
+// AddInput adds an input artifact. The manifest identifier may be empty.
+// Adding an artifact twice keeps the first entry.
+func (m *InputManifest) AddInput(artifact string, manifest string) error {
+	length, err := manifestHashLength(m.Algorithm)
+	if err != nil {
+		return err
+	}
+	if err := checkHex(artifact, length); err != nil {
+		return fmt.Errorf("invalid input artifact: %w", err)
+	}
+	if manifest != "" {
+		if err := checkHex(manifest, length); err != nil {
+			return fmt.Errorf("invalid input manifest: %w", err)
+		}
+	}
+	// keep the inputs sorted by artifact
+	i := sort.Search(len(m.Inputs), func(i int) bool {
+		return m.Inputs[i].Artifact >= artifact
+	})
+	if i < len(m.Inputs) && m.Inputs[i].Artifact == artifact {
+		return nil
+	}
+	m.Inputs = append(m.Inputs, ManifestInput{})
+	copy(m.Inputs[i+1:], m.Inputs[i:])
+	m.Inputs[i] = ManifestInput{Artifact: artifact, Manifest: manifest}
+	return nil
+}
+
+func (m *InputManifest) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "gitoid:blob:%s\n", m.Algorithm)
+	for _, input := range m.Inputs {
+		if input.Manifest != "" {
+			fmt.Fprintf(&b, "%s manifest %s\n", input.Artifact, input.Manifest)
+		} else {
+			fmt.Fprintf(&b, "%s\n", input.Artifact)
+		}
+	}
+	return b.String()
+}
+
+// Identity returns the hex gitoid of the manifest.
+func (m *InputManifest) Identity() string {
+	return adgIdentity(m.String(), m.Algorithm == "sha256")
+}
+
+// URI returns the identifier of the manifest as a gitoid URI.
+func (m *InputManifest) URI() string {
+	return fmt.Sprintf("gitoid:blob:%s:%s", m.Algorithm, m.Identity())
+}
+
+// ParseInputManifest parses the text form of an Input Manifest.
+func ParseInputManifest(b []byte) (*InputManifest, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	if !scanner.Scan() {
+		return nil, &ADGParseError{Line: 1, Msg: "missing header"}
+	}
+	header := scanner.Text()
+	if !strings.HasPrefix(header, "gitoid:blob:") {
+		return nil, &ADGParseError{Line: 1, Msg: fmt.Sprintf("invalid header %q", header)}
+	}
+	m, err := NewInputManifest(strings.TrimPrefix(header, "gitoid:blob:"))
+	if err != nil {
+		return nil, &ADGParseError{Line: 1, Msg: err.Error()}
+	}
+
+	lineNumber := 1
+	previous := ""
+	for scanner.Scan() {
+		lineNumber++
+		fields := strings.Split(scanner.Text(), " ")
+		manifest := ""
+		switch {
+		case len(fields) == 1:
+		case len(fields) == 3 && fields[1] == "manifest":
+			manifest = fields[2]
+		default:
+			return nil, &ADGParseError{Line: lineNumber, Msg: fmt.Sprintf("invalid input %q", scanner.Text())}
+		}
+		if fields[0] <= previous {
+			return nil, &ADGParseError{Line: lineNumber, Msg: "inputs are not in ascending order"}
+		}
+		previous = fields[0]
+		if err := m.AddInput(fields[0], manifest); err != nil {
+			return nil, &ADGParseError{Line: lineNumber, Msg: err.Error()}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(b, []byte("\n")) {
+		return nil, &ADGParseError{Line: lineNumber, Msg: "missing trailing newline"}
+	}
+	return m, nil
+}
+
+// InputManifests builds an Input Manifest for every directory of an
+// envelope, using the "sha1" or "sha256" gitoids of its entries. Files are
+// recorded by their gitoid. A directory has no content of its own, so it is
+// identified by its manifest and recorded with a reference to it.
+func InputManifests(envelope *Envelope, algorithm string) (map[string]*InputManifest, error) {
+	manifests := make(map[string]*InputManifest)
+	var directories []string
+	for path, element := range envelope.Mapping {
+		if element.Type == "directory" {
+			manifest, err := NewInputManifest(algorithm)
+			if err != nil {
+				return nil, err
+			}
+			manifests[path] = manifest
+			directories = append(directories, path)
+		}
+	}
+
+	for path, element := range envelope.Mapping {
+		parent, ok := manifests[filepath.Dir(path)]
+		if !ok || element.Type == "directory" || path == filepath.Dir(path) {
+			continue
+		}
+		if err := parent.AddInput(elementGitoid(element, "gitoid:"+algorithm), ""); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	// children before their parents
+	sort.Slice(directories, func(i, j int) bool {
+		return len(directories[i]) > len(directories[j])
+	})
+	for _, path := range directories {
+		if parent, ok := manifests[filepath.Dir(path)]; ok && path != filepath.Dir(path) {
+			identity := manifests[path].Identity()
+			if err := parent.AddInput(identity, identity); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return manifests, nil
+}
+
+// FormatInputManifestString renders manifests in the layout of
+// FormatADGString, each preceded by its gitoid URI and followed by "--".
+func FormatInputManifestString(manifests map[string]*InputManifest) string {
+	byURI := make(map[string]string)
+	for _, manifest := range manifests {
+		byURI[manifest.URI()] = manifest.String()
+	}
+	uris := make([]string, 0, len(byURI))
+	for uri := range byURI {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	res := ""
+	for _, uri := range uris {
+		res += fmt.Sprintln(uri)
+		res += fmt.Sprintln(byURI[uri])
+		res += fmt.Sprintln("--")
+	}
+	return res
+}
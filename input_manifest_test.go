@@ -0,0 +1,158 @@
+package omnitrail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The identities below were cross-checked with git hash-object, which computes
+// the same blob gitoids in sha1 and sha256 repositories.
+func TestInputManifestIdentity(t *testing.T) {
+	empty, err := NewInputManifest("sha256")
+	require.NoError(t, err)
+	assert.Equal(t, "gitoid:blob:sha256\n", empty.String())
+	assert.Equal(t, "5780f55500bd30463e4d9616559421fc512bd8debcdec4a23662763753f82895", empty.Identity())
+
+	m, err := NewInputManifest("sha256")
+	require.NoError(t, err)
+	require.NoError(t, m.AddInput("473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813", empty.Identity()))
+	require.NoError(t, m.AddInput("0bd69098bd9b9cc5934a610ab65da429b525361147faa7b5b922919e9a23143d", ""))
+	// duplicates are ignored
+	require.NoError(t, m.AddInput("0bd69098bd9b9cc5934a610ab65da429b525361147faa7b5b922919e9a23143d", ""))
+	assert.Equal(t, "gitoid:blob:sha256\n"+
+		"0bd69098bd9b9cc5934a610ab65da429b525361147faa7b5b922919e9a23143d\n"+
+		"473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813 manifest 5780f55500bd30463e4d9616559421fc512bd8debcdec4a23662763753f82895\n",
+		m.String())
+	assert.Equal(t, "gitoid:blob:sha256:7cc126bdf5f8407046c2268f188ce76ac24519d41efbc87030e706076de2c2e5", m.URI())
+
+	sha1, err := NewInputManifest("sha1")
+	require.NoError(t, err)
+	require.NoError(t, sha1.AddInput("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391", ""))
+	require.NoError(t, sha1.AddInput("3b18e512dba79e4c8300dd08aeb37f8e728b8dad", ""))
+	assert.Equal(t, "33d55f3c08f6a1a4305d7e74a50a1f358240cc16", sha1.Identity())
+
+	assert.Error(t, sha1.AddInput("0bd69098bd9b9cc5934a610ab65da429b525361147faa7b5b922919e9a23143d", ""))
+	_, err = NewInputManifest("md5")
+	assert.Error(t, err)
+}
+
+func TestParseInputManifest(t *testing.T) {
+	text := "gitoid:blob:sha1\n" +
+		"3b18e512dba79e4c8300dd08aeb37f8e728b8dad\n" +
+		"e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 manifest 33d55f3c08f6a1a4305d7e74a50a1f358240cc16\n"
+	m, err := ParseInputManifest([]byte(text))
+	require.NoError(t, err)
+	assert.Equal(t, "sha1", m.Algorithm)
+	assert.Equal(t, []ManifestInput{
+		{Artifact: "3b18e512dba79e4c8300dd08aeb37f8e728b8dad"},
+		{Artifact: "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391", Manifest: "33d55f3c08f6a1a4305d7e74a50a1f358240cc16"},
+	}, m.Inputs)
+	assert.Equal(t, text, m.String())
+
+	tests := map[string]struct {
+		text string
+		line int
+	}{
+		"empty":               {"", 1},
+		"header":              {"gitoid:tree:sha1\n", 1},
+		"algorithm":           {"gitoid:blob:md5\n", 1},
+		"order":               {"gitoid:blob:sha1\ne69de29bb2d1d6434b8b29ae775ad8c2e48c5391\n3b18e512dba79e4c8300dd08aeb37f8e728b8dad\n", 3},
+		"duplicate":           {"gitoid:blob:sha1\ne69de29bb2d1d6434b8b29ae775ad8c2e48c5391\ne69de29bb2d1d6434b8b29ae775ad8c2e48c5391\n", 3},
+		"length":              {"gitoid:blob:sha256\ne69de29bb2d1d6434b8b29ae775ad8c2e48c5391\n", 2},
+		"keyword":             {"gitoid:blob:sha1\ne69de29bb2d1d6434b8b29ae775ad8c2e48c5391 bom 33d55f3c08f6a1a4305d7e74a50a1f358240cc16\n", 2},
+		"uppercase":           {"gitoid:blob:sha1\nE69DE29BB2D1D6434B8B29AE775AD8C2E48C5391\n", 2},
+		"no trailing newline": {"gitoid:blob:sha1\ne69de29bb2d1d6434b8b29ae775ad8c2e48c5391", 2},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseInputManifest([]byte(test.text))
+			var parseErr *ADGParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, test.line, parseErr.Line)
+		})
+	}
+}
+
+func TestInputManifests(t *testing.T) {
+	envelope := rebase(loadEnvelope(t, "deep"), "/deep")
+	manifests, err := InputManifests(envelope, "sha256")
+	require.NoError(t, err)
+	require.Len(t, manifests, 3)
+
+	dir2 := manifests["/deep/dir1/dir2"]
+	assert.Equal(t, "gitoid:blob:sha256\n73bae245b03a7c9d540831beca1987b2a34f8bec948d032132d09224b613d186\n", dir2.String())
+	assert.Equal(t, "a1d4ddda11382c96f9f8dca0874feaa9fe98cc64346ecccc9cc22f161b70322c", dir2.Identity())
+
+	dir1 := manifests["/deep/dir1"]
+	assert.Equal(t, []ManifestInput{
+		{Artifact: "2da00b9c51e5e7554c44a3929f9f8cee3c4e742d4f71bec0d4e1d37256d17207"},
+		{Artifact: dir2.Identity(), Manifest: dir2.Identity()},
+	}, dir1.Inputs)
+	assert.Equal(t, "a1daf1cdcfe2fdffb86e96785c5c5c1e6b3e0de9140371af41016add37f000f9", dir1.Identity())
+
+	root := manifests["/deep"]
+	assert.Len(t, root.Inputs, 2)
+	assert.Contains(t, root.Inputs, ManifestInput{Artifact: dir1.Identity(), Manifest: dir1.Identity()})
+
+	text := FormatInputManifestString(manifests)
+	assert.Contains(t, text, "gitoid:blob:sha256:a1d4ddda11382c96f9f8dca0874feaa9fe98cc64346ecccc9cc22f161b70322c\n"+dir2.String()+"\n--\n")
+
+	_, err = InputManifests(envelope, "md5")
+	assert.Error(t, err)
+}
+
+// TestInputManifestPublishedVectors checks the artifact identifiers against
+// the published gitoid vectors: the ids git documents for the empty blob,
+// and the examples of the gitoid reference implementation for "example" and
+// the Apache 2.0 license, which is the LICENSE of this repository. All ids,
+// including the manifest identifiers, were computed independently with git
+// hash-object in sha1 and sha256 repositories.
+func TestInputManifestPublishedVectors(t *testing.T) {
+	license, err := os.ReadFile("LICENSE")
+	require.NoError(t, err)
+	dir, err := filepath.Abs(t.TempDir())
+	require.NoError(t, err)
+	for name, content := range map[string][]byte{"LICENSE": license, "example": []byte("example"), "empty": nil} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), content, 0644))
+	}
+	trail := NewTrail()
+	require.NoError(t, trail.Add(dir))
+
+	for algorithm, expected := range map[string]struct {
+		artifacts []string
+		identity  string
+	}{
+		"sha1": {
+			artifacts: []string{
+				"261eeb9e9f8b2b4b0d119366dda99c6fd7d35c64",
+				"96236f8158b12701d5e75c14fb876c4a0f31b963",
+				"e69de29bb2d1d6434b8b29ae775ad8c2e48c5391",
+			},
+			identity: "44b4fedd4dbe52738ba0a5847a3553870f2cc4c2",
+		},
+		"sha256": {
+			artifacts: []string{
+				"473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813",
+				"b32d8f166adfa017e9cb0d57e0777f6e9b09aa3b03c84f8f98fc5995c5dcea9d",
+				"ed43975fbdc3084195eb94723b5f6df44eeeed1cdda7db0c7121edf5d84569ab",
+			},
+			identity: "817b4db998eb98360fe45c221d4ecc63598e1240ffe6fe4a156cca48730a756e",
+		},
+	} {
+		manifests, err := InputManifests(trail.Envelope(), algorithm)
+		require.NoError(t, err)
+		m := manifests[dir]
+		var artifacts []string
+		for _, input := range m.Inputs {
+			artifacts = append(artifacts, input.Artifact)
+			assert.Empty(t, input.Manifest, algorithm)
+		}
+		assert.Equal(t, expected.artifacts, artifacts, algorithm)
+		assert.Equal(t, expected.identity, m.Identity(), algorithm)
+		assert.Equal(t, "gitoid:blob:"+algorithm+":"+expected.identity, m.URI(), algorithm)
+	}
+}
@@ -0,0 +1,149 @@
+package omnitrail
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// PatchOperation is a single RFC 6902 JSON Patch operation.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	From  string      `json:"from,omitempty"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// JSONPatch renders the diff as an RFC 6902 JSON Patch that transforms the
+// old envelope into the new one. Entries of the new envelope are placed under
+// the root of the old envelope. Renames and moves are applied first, followed
+// by copies, additions and modifications, and finally removals. An error is
+// returned if an element or feature cannot be represented as JSON.
+func (d *EnvelopeDiff) JSONPatch() ([]PatchOperation, error) {
+	ops := make([]PatchOperation, 0, len(d.Changes))
+
+	oldFeatures := make(map[string]interface{})
+	newFeatures := make(map[string]interface{})
+	for _, name := range d.Features {
+		if feature, ok := d.old.Header.Features[name]; ok {
+			value, err := jsonValue(feature)
+			if err != nil {
+				return nil, err
+			}
+			oldFeatures[name] = value
+		}
+		if feature, ok := d.new.Header.Features[name]; ok {
+			value, err := jsonValue(feature)
+			if err != nil {
+				return nil, err
+			}
+			newFeatures[name] = value
+		}
+	}
+	ops = diffJSON("/header/features", oldFeatures, newFeatures, ops)
+
+	pointer := func(p string) string {
+		return mappingPointer(absoluteKey(d.OldRoot, p))
+	}
+
+	// the location of moved entries once the moves have been applied
+	moved := make(map[string]string)
+	for _, change := range d.Changes {
+		switch change.Kind {
+		case ChangeRenamed, ChangeMoved:
+			ops = append(ops, PatchOperation{Op: "move", From: pointer(change.From), Path: pointer(change.Path)})
+			moved[change.From] = change.Path
+			for _, suffix := range change.moved {
+				ops = append(ops, PatchOperation{Op: "move", From: pointer(change.From + suffix), Path: pointer(change.Path + suffix)})
+				moved[change.From+suffix] = change.Path + suffix
+			}
+		}
+	}
+
+	for _, change := range d.Changes {
+		if change.Kind == ChangeCopied {
+			from := change.From
+			if to, ok := moved[from]; ok {
+				from = to
+			}
+			ops = append(ops, PatchOperation{Op: "copy", From: pointer(from), Path: pointer(change.Path)})
+		}
+	}
+
+	for _, change := range d.Changes {
+		switch change.Kind {
+		case ChangeAdded:
+			ops = append(ops, PatchOperation{Op: "add", Path: pointer(change.Path), Value: change.New})
+		case ChangeRemoved:
+			// removals are applied last, after copies read from them
+		case ChangeType:
+			ops = append(ops, PatchOperation{Op: "replace", Path: pointer(change.Path), Value: change.New})
+		default:
+			oldJSON, err := elementJSON(change.Old)
+			if err != nil {
+				return nil, err
+			}
+			newJSON, err := elementJSON(change.New)
+			if err != nil {
+				return nil, err
+			}
+			ops = diffJSON(pointer(change.Path), oldJSON, newJSON, ops)
+		}
+	}
+
+	for _, change := range d.Changes {
+		if change.Kind == ChangeRemoved {
+			ops = append(ops, PatchOperation{Op: "remove", Path: pointer(change.Path)})
+		}
+	}
+	return ops, nil
+}
+
+func diffJSON(pointer string, a, b interface{}, ops []PatchOperation) []PatchOperation {
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+	if !aok || !bok {
+		if !reflect.DeepEqual(a, b) {
+			ops = append(ops, PatchOperation{Op: "replace", Path: pointer, Value: b})
+		}
+		return ops
+	}
+
+	keys := make([]string, 0, len(am)+len(bm))
+	for k := range am {
+		keys = append(keys, k)
+	}
+	for k := range bm {
+		if _, ok := am[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		av, inA := am[k]
+		bv, inB := bm[k]
+		child := pointer + "/" + escapeJSONPointer(k)
+		switch {
+		case !inA:
+			ops = append(ops, PatchOperation{Op: "add", Path: child, Value: bv})
+		case !inB:
+			ops = append(ops, PatchOperation{Op: "remove", Path: child})
+		default:
+			ops = diffJSON(child, av, bv, ops)
+		}
+	}
+	return ops
+}
+
+func jsonValue(v interface{}) (interface{}, error) {
+	var res interface{}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
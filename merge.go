@@ -0,0 +1,358 @@
+package omnitrail
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MergePolicy decides how conflicting entries are resolved by Merge.
+type MergePolicy int
+
+const (
+	// MergeFail refuses to merge envelopes with conflicting digests.
+	MergeFail MergePolicy = iota
+	// MergeKeepFirst keeps the entry of the first envelope that has it.
+	MergeKeepFirst
+	// MergeKeepLast keeps the entry of the last envelope that has it.
+	MergeKeepLast
+)
+
+type MergeOption func(o *MergeOptions)
+
+type MergeOptions struct {
+	Policy MergePolicy
+}
+
+func WithMergePolicy(policy MergePolicy) MergeOption {
+	return func(o *MergeOptions) {
+		o.Policy = policy
+	}
+}
+
+// MergeConflict is a path whose digests differ between envelopes. Elements
+// holds every distinct version in the order of the merged envelopes.
+type MergeConflict struct {
+	Path     string              `json:"path"`
+	Features map[string][]string `json:"features"`
+	Elements []*Element          `json:"elements"`
+}
+
+type MergeConflictError struct {
+	Conflicts []MergeConflict
+}
+
+func (e *MergeConflictError) Error() string {
+	paths := make([]string, 0, len(e.Conflicts))
+	for _, conflict := range e.Conflicts {
+		paths = append(paths, conflict.Path)
+	}
+	return fmt.Sprintf("conflicting digests for %s", strings.Join(paths, ", "))
+}
+
+type MergeResult struct {
+	Envelope  *Envelope
+	ADGs      *ADGSet
+	Conflicts []MergeConflict
+}
+
+// Merge combines envelopes into one trail. Mappings are unioned by key. A
+// feature is kept if every envelope declares it, with the algorithms all of
+// them share. Directory gitoids and ADGs are recomputed from the merged
+// mapping, so directories present in several envelopes are self-consistent.
+// Git trees, NAR and h1 hashes and SWHIDs cannot be recomputed, so they are
+// kept for directories whose subtree is the same in every envelope that has
+// them, and cleared on the others, whose algorithms are then dropped from
+// the header.
+func Merge(envelopes []*Envelope, option ...MergeOption) (*MergeResult, error) {
+	o := &MergeOptions{}
+	for _, opt := range option {
+		opt(o)
+	}
+
+	result := &MergeResult{
+		Envelope: &Envelope{
+			Header: Header{
+				Features: mergeFeatures(envelopes),
+				Policy:   mergePolicy(envelopes),
+			},
+			Mapping: make(map[string]*Element),
+		},
+		ADGs: NewADGSet(),
+	}
+
+	versions := make(map[string][]*Element)
+	for _, envelope := range envelopes {
+		for path, element := range envelope.Mapping {
+			versions[path] = append(versions[path], element)
+		}
+	}
+
+	for _, path := range sortedVersions(versions) {
+		elements := versions[path]
+		chosen := elements[0]
+		if o.Policy == MergeKeepLast {
+			chosen = elements[len(elements)-1]
+		}
+		result.Envelope.Mapping[path] = chosen.clone()
+
+		conflict := MergeConflict{Path: path, Features: make(map[string][]string)}
+		for _, element := range elements[1:] {
+			change, ok := compareElements(path, elements[0], element)
+			if !ok || !isConflict(change) {
+				continue
+			}
+			for feature, fields := range change.Features {
+				conflict.Features[feature] = appendMissing(conflict.Features[feature], fields...)
+			}
+			conflict.Elements = appendDistinct(conflict.Elements, elements[0], element)
+		}
+		if len(conflict.Elements) > 0 {
+			result.Conflicts = append(result.Conflicts, conflict)
+		}
+	}
+
+	if o.Policy == MergeFail && len(result.Conflicts) > 0 {
+		return nil, &MergeConflictError{Conflicts: result.Conflicts}
+	}
+
+	if err := result.recomputeDirectories(envelopes); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// isConflict reports whether a change between two versions of the same
+// directory cannot be reconciled. Directory digests are recomputed, so only
+// the type and the digests of other elements can conflict.
+func isConflict(change Change) bool {
+	if change.Kind == ChangeType {
+		return true
+	}
+	return change.Kind == ChangeContent && change.New.Type != "directory"
+}
+
+func (result *MergeResult) recomputeDirectories(envelopes []*Envelope) error {
+	mapping := result.Envelope.Mapping
+	var directories []string
+	var changed []string
+	for path, element := range mapping {
+		if element.Type == "directory" {
+			directories = append(directories, path)
+			if subtreeChanged(path, mapping, envelopes) {
+				changed = append(changed, path)
+			}
+		}
+	}
+
+	algorithms := make(map[string]bool)
+	feature := result.Envelope.Header.Features["directory"]
+	kept := []string{}
+	for _, algorithm := range feature.Algorithms {
+		// git trees, NAR and h1 hashes need file modes, link targets or
+		// module paths the envelope lacks, so they are only kept if no
+		// directory changed
+		if _, err := newArtifactTree(algorithm); err == nil {
+			algorithms[algorithm] = true
+			kept = append(kept, algorithm)
+		} else if len(changed) == 0 {
+			kept = append(kept, algorithm)
+		}
+	}
+	if len(feature.Algorithms) > 0 {
+		feature.Algorithms = kept
+		result.Envelope.Header.Features["directory"] = feature
+	}
+	if len(changed) > 0 {
+		delete(result.Envelope.Header.Features, "swhid")
+	}
+	for _, path := range directories {
+		for _, algorithm := range []string{"gitoid:sha1", "gitoid:sha256"} {
+			if !algorithms[algorithm] {
+				setElementGitoid(mapping[path], algorithm, "")
+			}
+		}
+	}
+	// clear digests of changed directories that cannot be recomputed
+	for _, path := range changed {
+		for _, algorithm := range []string{"gittree:sha1", "gittree:sha256"} {
+			setElementGitTree(mapping[path], algorithm, "")
+		}
+		mapping[path].SWHID = ""
+		mapping[path].NarSha256 = ""
+		mapping[path].H1 = ""
+	}
+
+	for algorithm := range algorithms {
+		trees, err := buildADGs(mapping, directories, algorithm)
+		if err != nil {
+			return err
+		}
+		for path, tree := range trees {
+			setElementGitoid(mapping[path], algorithm, tree.Identity())
+		}
+		result.ADGs.add(algorithm, trees, mapping)
+	}
+	return nil
+}
+
+// subtreeChanged reports whether the entries below a directory differ from
+// those of an envelope that has the directory, so that its digests no longer
+// describe it.
+func subtreeChanged(dir string, mapping map[string]*Element, envelopes []*Envelope) bool {
+	below := 0
+	for path := range mapping {
+		if path != dir && isWithin(path, dir) {
+			below++
+		}
+	}
+	for _, envelope := range envelopes {
+		if _, ok := envelope.Mapping[dir]; !ok {
+			continue
+		}
+		count := 0
+		for path, element := range envelope.Mapping {
+			if path == dir || !isWithin(path, dir) {
+				continue
+			}
+			count++
+			merged, ok := mapping[path]
+			if !ok || !sameEntry(merged, element) {
+				return true
+			}
+		}
+		if count != below {
+			return true
+		}
+	}
+	return false
+}
+
+// sameEntry reports whether two versions of an entry give their parent
+// directories the same digests. Directories only matter by their entries,
+// which are compared on their own.
+func sameEntry(a, b *Element) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	if a.Type == "directory" {
+		return true
+	}
+	var aMode, bMode string
+	if a.Posix != nil {
+		aMode = a.Posix.Permissions
+	}
+	if b.Posix != nil {
+		bMode = b.Posix.Permissions
+	}
+	return aMode == bMode && reflect.DeepEqual(elementDigests(a), elementDigests(b))
+}
+
+func mergeFeatures(envelopes []*Envelope) map[string]Feature {
+	features := make(map[string]Feature)
+	if len(envelopes) == 0 {
+		return features
+	}
+	for name, feature := range envelopes[0].Header.Features {
+		algorithms := append([]string(nil), feature.Algorithms...)
+		declared := true
+		// xattr filters are only kept if every envelope was recorded with
+		// them, as the merged trail holds what every filter allowed, and
+		// timestamps are only excluded if every envelope left them out
+		filtered := true
+		excludeTimestamps := feature.ExcludeTimestamps
+		for _, envelope := range envelopes[1:] {
+			other, ok := envelope.Header.Features[name]
+			if !ok {
+				declared = false
+				break
+			}
+			algorithms = intersect(algorithms, other.Algorithms)
+			excludeTimestamps = excludeTimestamps && other.ExcludeTimestamps
+			filtered = filtered && reflect.DeepEqual(feature.XattrAllow, other.XattrAllow) && reflect.DeepEqual(feature.XattrDeny, other.XattrDeny)
+		}
+		if declared {
+			merged := Feature{Algorithms: algorithms, ExcludeTimestamps: excludeTimestamps}
+			if filtered {
+				merged.XattrAllow = append([]string(nil), feature.XattrAllow...)
+				merged.XattrDeny = append([]string(nil), feature.XattrDeny...)
+			}
+			features[name] = merged
+		}
+	}
+	return features
+}
+
+// mergePolicy keeps the policy of the envelopes if they all share it.
+func mergePolicy(envelopes []*Envelope) *Policy {
+	if len(envelopes) == 0 || envelopes[0].Header.Policy == nil {
+		return nil
+	}
+	for _, envelope := range envelopes[1:] {
+		if !envelopes[0].Header.Policy.equal(envelope.Header.Policy) {
+			return nil
+		}
+	}
+	policy := *envelopes[0].Header.Policy
+	policy.Algorithms = append([]string(nil), policy.Algorithms...)
+	return &policy
+}
+
+func intersect(a, b []string) []string {
+	if a == nil {
+		return nil
+	}
+	res := make([]string, 0, len(a))
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				res = append(res, x)
+				break
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func appendMissing(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+func appendDistinct(list []*Element, elements ...*Element) []*Element {
+	for _, element := range elements {
+		found := false
+		for _, existing := range list {
+			if existing == element {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, element)
+		}
+	}
+	return list
+}
+
+func sortedVersions(m map[string][]*Element) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
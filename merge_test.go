@@ -0,0 +1,119 @@
+package omnitrail
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// splitDeep returns two partial trails of test/deep which together cover the
+// whole tree. Their directory gitoids are stale until they are merged.
+func splitDeep(t *testing.T) (*Envelope, *Envelope) {
+	a := rebase(loadEnvelope(t, "deep"), "/deep")
+	b := rebase(a, "/deep")
+	delete(a.Mapping, "/deep/dir1/file1.txt")
+	delete(b.Mapping, "/deep/root.txt")
+	delete(b.Mapping, "/deep/dir1/dir2/file2.txt")
+	return a, b
+}
+
+func TestMergeOverlappingTrees(t *testing.T) {
+	a, b := splitDeep(t)
+	result, err := Merge([]*Envelope{a, b})
+	require.NoError(t, err)
+	assert.Empty(t, result.Conflicts)
+
+	expected := rebase(loadEnvelope(t, "deep"), "/deep")
+	assert.Equal(t, expected, result.Envelope)
+
+	expectedADG, err := os.ReadFile("./test/deep.adg")
+	require.NoError(t, err)
+	assert.Equal(t, string(expectedADG), FormatADGString(result.ADGs))
+}
+
+func TestMergeConflicts(t *testing.T) {
+	a, b := splitDeep(t)
+	b.Mapping["/deep/root.txt"] = a.Mapping["/deep/root.txt"].clone()
+	b.Mapping["/deep/root.txt"].Sha256 = "00"
+	b.Mapping["/deep/root.txt"].Posix.Permissions = "-rwxr-xr-x"
+
+	_, err := Merge([]*Envelope{a, b})
+	var conflictErr *MergeConflictError
+	require.True(t, errors.As(err, &conflictErr))
+	require.Len(t, conflictErr.Conflicts, 1)
+	assert.Equal(t, "/deep/root.txt", conflictErr.Conflicts[0].Path)
+	assert.Equal(t, map[string][]string{
+		"file":  {"sha256"},
+		"posix": {"posix.permissions"},
+	}, conflictErr.Conflicts[0].Features)
+
+	result, err := Merge([]*Envelope{a, b}, WithMergePolicy(MergeKeepLast))
+	require.NoError(t, err)
+	assert.Len(t, result.Conflicts, 1)
+	assert.Equal(t, "00", result.Envelope.Mapping["/deep/root.txt"].Sha256)
+
+	result, err = Merge([]*Envelope{a, b}, WithMergePolicy(MergeKeepFirst))
+	require.NoError(t, err)
+	assert.Equal(t, a.Mapping["/deep/root.txt"], result.Envelope.Mapping["/deep/root.txt"])
+}
+
+func TestMergeFeatures(t *testing.T) {
+	a := rebase(loadEnvelope(t, "one-file"), "/a")
+	b := rebase(loadEnvelope(t, "two-files"), "/b")
+	b.Header.Features = map[string]Feature{
+		"directory": {Algorithms: []string{"gitoid:sha256"}},
+		"file":      {Algorithms: []string{"gitoid:sha256", "sha256"}},
+	}
+
+	result, err := Merge([]*Envelope{a, b})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Feature{
+		"directory": {Algorithms: []string{"gitoid:sha256"}},
+		"file":      {Algorithms: []string{"gitoid:sha256", "sha256"}},
+	}, result.Envelope.Header.Features)
+	assert.Empty(t, result.Envelope.Mapping["/a"].Sha1Gitoid)
+	assert.Equal(t, a.Mapping["/a"].Sha256Gitoid, result.Envelope.Mapping["/a"].Sha256Gitoid)
+	assert.Equal(t, b.Mapping["/b"].Sha256Gitoid, result.Envelope.Mapping["/b"].Sha256Gitoid)
+	assert.Empty(t, result.ADGs.Sha1ADGs())
+}
+
+func TestMergeDropsGitTrees(t *testing.T) {
+	trees := func(envelope *Envelope) *Envelope {
+		features := map[string]Feature{"swhid": {}}
+		for name, feature := range envelope.Header.Features {
+			features[name] = feature
+		}
+		features["directory"] = Feature{Algorithms: []string{"gitoid:sha1", "gitoid:sha256", "gittree:sha1"}}
+		envelope.Header.Features = features
+		for _, path := range []string{"/deep", "/deep/dir1", "/deep/dir1/dir2"} {
+			envelope.Mapping[path].Sha1GitTree = "tree of " + path
+			envelope.Mapping[path].SWHID = "swh:1:dir:tree of " + path
+		}
+		return envelope
+	}
+	a := trees(rebase(loadEnvelope(t, "deep"), "/deep"))
+	b := trees(rebase(a, "/deep"))
+
+	// directories that are the same in every envelope keep their trees
+	result, err := Merge([]*Envelope{a, b})
+	require.NoError(t, err)
+	assert.Equal(t, a.Header.Features, result.Envelope.Header.Features)
+	assert.Equal(t, "tree of /deep", result.Envelope.Mapping["/deep"].Sha1GitTree)
+
+	// a file only one envelope has changes its parents, whose trees cannot be
+	// recomputed
+	delete(b.Mapping, "/deep/dir1/file1.txt")
+	result, err = Merge([]*Envelope{a, b})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gitoid:sha1", "gitoid:sha256"}, result.Envelope.Header.Features["directory"].Algorithms)
+	assert.NotContains(t, result.Envelope.Header.Features, "swhid")
+	for _, path := range []string{"/deep", "/deep/dir1"} {
+		assert.Empty(t, result.Envelope.Mapping[path].Sha1GitTree, path)
+		assert.Empty(t, result.Envelope.Mapping[path].SWHID, path)
+	}
+	assert.Equal(t, "tree of /deep/dir1/dir2", result.Envelope.Mapping["/deep/dir1/dir2"].Sha1GitTree)
+	assert.Equal(t, "swh:1:dir:tree of /deep/dir1/dir2", result.Envelope.Mapping["/deep/dir1/dir2"].SWHID)
+}
@@ -0,0 +1,147 @@
+package omnitrail
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// narHasher streams the NAR serialization of a tree, as written by
+// nix-store --dump, into the hash of every directory being serialized, so
+// that each file is read once however deep it is nested.
+type narHasher struct {
+	// hashes of the directories on the current path, outermost first
+	stack []hash.Hash
+	// directory -> "sha256-<base64>"
+	results map[string]string
+}
+
+func (n *narHasher) Write(p []byte) (int, error) {
+	for _, h := range n.stack {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+func (n *narHasher) writeString(s string) {
+	writeNARString(n, s)
+}
+
+// writeNARString writes a length prefixed string padded to 8 bytes.
+func writeNARString(w io.Writer, s string) {
+	writeNARLength(w, uint64(len(s)))
+	io.WriteString(w, s)
+	writeNARPadding(w, uint64(len(s)))
+}
+
+func writeNARLength(w io.Writer, length uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], length)
+	w.Write(b[:])
+}
+
+func writeNARPadding(w io.Writer, length uint64) {
+	if rest := length % 8; rest != 0 {
+		w.Write(make([]byte, 8-rest))
+	}
+}
+
+// dump serializes path. Directories start a NAR of their own in addition to
+// being part of the NAR of their parents.
+func (n *narHasher) dump(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		h := sha256.New()
+		writeNARString(h, "nix-archive-1")
+		n.stack = append(n.stack, h)
+		defer func() {
+			n.stack = n.stack[:len(n.stack)-1]
+			n.results[path] = "sha256-" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+		}()
+	}
+
+	n.writeString("(")
+	n.writeString("type")
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		n.writeString("symlink")
+		n.writeString("target")
+		n.writeString(target)
+	case info.Mode().IsRegular():
+		n.writeString("regular")
+		if info.Mode()&0100 != 0 {
+			n.writeString("executable")
+			n.writeString("")
+		}
+		n.writeString("contents")
+		if err := n.writeFile(path, info.Size()); err != nil {
+			return err
+		}
+	case info.IsDir():
+		n.writeString("directory")
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		// ReadDir sorts by name, which is the byte order NAR requires
+		for _, entry := range entries {
+			n.writeString("entry")
+			n.writeString("(")
+			n.writeString("name")
+			n.writeString(entry.Name())
+			n.writeString("node")
+			if err := n.dump(filepath.Join(path, entry.Name())); err != nil {
+				return err
+			}
+			n.writeString(")")
+		}
+	default:
+		return fmt.Errorf("%s: NAR cannot hold %s", path, info.Mode().Type())
+	}
+	n.writeString(")")
+	return nil
+}
+
+func (n *narHasher) writeFile(path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	writeNARLength(n, uint64(size))
+	written, err := io.Copy(n, f)
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("%s changed size while hashing", path)
+	}
+	writeNARPadding(n, uint64(size))
+	return nil
+}
+
+// narHashes returns the "nar:sha256" SRI hash, such as "sha256-<base64>",
+// of every directory below and including the given roots.
+func narHashes(roots []string) (map[string]string, error) {
+	n := &narHasher{results: make(map[string]string)}
+	for _, root := range roots {
+		if err := n.dump(root); err != nil {
+			return nil, err
+		}
+	}
+	return n.results, nil
+}
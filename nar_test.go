@@ -0,0 +1,107 @@
+package omnitrail
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The NAR hash of an empty directory is the one Nix reports for
+// pkgs.emptyDirectory. The other hashes were cross-checked with a separate
+// implementation of nix-store --dump, as nix is not available to the tests.
+func TestNARHash(t *testing.T) {
+	dir := writeGitLayout(t)
+	trail := NewTrail(WithDirectoryAlgorithms("nar:sha256"))
+	require.NoError(t, trail.Add(dir))
+	envelope := trail.Envelope()
+	assert.Contains(t, envelope.Header.Features["directory"].Algorithms, "nar:sha256")
+
+	expected := map[string]string{
+		".":            "sha256-SrON6KCYM0nXLtmXUA6O+emNlo24+uVY7/ffMtoQwUY=",
+		"src":          "sha256-VWIOj4OeY064y4Dki5pzLJD1UVkqC6950BdcHtv3L+U=",
+		"empty":        "sha256-9Pnqw8HA0S4y7dfai5tdTPM6R23076Ncflf6rf6kU04=",
+		"empty/nested": "sha256-pQpattmS9VmO3ZIQUFn66az8GSmB4IvYhTTCFn6SUmo=",
+		// an executable
+		"bin": "sha256-KFQTpww4cTPtyJ+sKKYLkpT4pvYNQWYVB3UDx6DawZs=",
+	}
+	for rel, hash := range expected {
+		assert.Equal(t, hash, envelope.Mapping[filepath.Join(dir, rel)].NarSha256, rel)
+	}
+	assert.Empty(t, envelope.Mapping[filepath.Join(dir, "README")].NarSha256)
+}
+
+// narLayout has one directory per kind of NAR node, holding a regular file,
+// an executable and a symlink.
+func narLayout(t *testing.T) string {
+	dir, err := filepath.Abs(t.TempDir())
+	require.NoError(t, err)
+	for _, sub := range []string{"regular", "executable", "symlink"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, sub), 0755))
+	}
+	for sub, mode := range map[string]os.FileMode{"regular": 0644, "executable": 0755} {
+		path := filepath.Join(dir, sub, "hello")
+		require.NoError(t, os.WriteFile(path, []byte("hello world\n"), mode))
+		require.NoError(t, os.Chmod(path, mode))
+	}
+	require.NoError(t, os.Symlink("../regular/hello", filepath.Join(dir, "symlink", "hello")))
+	return dir
+}
+
+// narBytes writes the tokens of a NAR as the format describes them: every
+// string is prefixed by its little endian 64 bit length and padded with zeros
+// to a multiple of 8 bytes.
+func narBytes(tokens ...string) []byte {
+	var b bytes.Buffer
+	for _, token := range tokens {
+		var length [8]byte
+		binary.LittleEndian.PutUint64(length[:], uint64(len(token)))
+		b.Write(length[:])
+		b.WriteString(token)
+		b.Write(make([]byte, (8-len(token)%8)%8))
+	}
+	return b.Bytes()
+}
+
+func narSRI(nar []byte) string {
+	sum := sha256.Sum256(nar)
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// TestNARNodes checks every kind of node against the serialization of the
+// NAR format, and against nix-store --dump where nix is installed.
+func TestNARNodes(t *testing.T) {
+	dir := narLayout(t)
+	trail := NewTrail(WithDirectoryAlgorithms("nar:sha256"))
+	require.NoError(t, trail.Add(dir))
+	mapping := trail.Envelope().Mapping
+
+	entry := func(node ...string) []string {
+		tokens := []string{"nix-archive-1", "(", "type", "directory", "entry", "(", "name", "hello", "node", "(", "type"}
+		return append(append(tokens, node...), ")", ")", ")")
+	}
+	expected := map[string][]string{
+		"regular":    entry("regular", "contents", "hello world\n"),
+		"executable": entry("regular", "executable", "", "contents", "hello world\n"),
+		"symlink":    entry("symlink", "target", "../regular/hello"),
+	}
+	for sub, tokens := range expected {
+		assert.Equal(t, narSRI(narBytes(tokens...)), mapping[filepath.Join(dir, sub)].NarSha256, sub)
+	}
+
+	if _, err := exec.LookPath("nix-store"); err != nil {
+		t.Skip("nix-store is not available")
+	}
+	for sub := range expected {
+		nar, err := exec.Command("nix-store", "--dump", filepath.Join(dir, sub)).Output()
+		require.NoError(t, err, sub)
+		assert.Equal(t, narSRI(nar), mapping[filepath.Join(dir, sub)].NarSha256, sub)
+	}
+}
@@ -9,10 +9,66 @@ type PluginInit func() Plugin
 
 var pluginMap = make(map[string]PluginInit)
 
+// optionalPluginMap holds plugins that are only loaded on request through
+// WithPlugins.
+var optionalPluginMap = make(map[string]PluginInit)
+
 func RegisterPlugin(name string, initFn PluginInit) {
 	pluginMap[name] = initFn
 }
 
+func RegisterOptionalPlugin(name string, initFn PluginInit) {
+	optionalPluginMap[name] = initFn
+}
+
+// WithPlugins loads optional plugins by name. Adding paths to the trail fails
+// if a plugin is not known.
+func WithPlugins(names ...string) Option {
+	return func(o *Options) {
+		o.Plugins = append(o.Plugins, names...)
+	}
+}
+
+// WithFileAlgorithms enables additional file digests by their registered
+// name, such as "sha384", "sha512", "sha512/256" or "sha3-256".
+func WithFileAlgorithms(algorithms ...string) Option {
+	return func(o *Options) {
+		o.FileAlgorithms = append(o.FileAlgorithms, algorithms...)
+	}
+}
+
+// WithDirectoryAlgorithms enables additional directory algorithms, such as
+// "gittree:sha1" and "gittree:sha256" for git tree object ids,
+// "nar:sha256" for the Nix NAR hash, or "h1" for the go.sum hash of module
+// directories.
+func WithDirectoryAlgorithms(algorithms ...string) Option {
+	return func(o *Options) {
+		o.DirectoryAlgorithms = append(o.DirectoryAlgorithms, algorithms...)
+	}
+}
+
+// WithoutVolatileTimestamps leaves out the access, change and birth times,
+// which differ between checkouts of the same tree and change when the tree is
+// read. The modification time is kept, as reproducible builds normalize it,
+// for example to SOURCE_DATE_EPOCH; trees whose modification times are not
+// normalized, such as git checkouts and copies, need WithoutTimestamps to
+// stay stable.
+func WithoutVolatileTimestamps() Option {
+	return func(o *Options) {
+		o.ExcludeVolatileTimestamps = true
+	}
+}
+
+// WithoutTimestamps leaves out every timestamp, including the modification
+// time, so that trails of the same content are stable across checkouts and
+// copies. It is recorded in the posix feature, so Verify leaves them out as
+// well.
+func WithoutTimestamps() Option {
+	return func(o *Options) {
+		o.ExcludeTimestamps = true
+	}
+}
+
 func NewTrail(option ...Option) Factory {
 	o := &Options{}
 	for _, opt := range option {
@@ -31,6 +87,41 @@ func NewTrail(option ...Option) Factory {
 	for _, pluginInitFunc := range pluginMap {
 		plugins = append(plugins, pluginInitFunc())
 	}
+	var err error
+	var policy *Policy
+	if o.Policy != "" {
+		var ok bool
+		if policy, ok = LookupPolicy(o.Policy); !ok {
+			err = fmt.Errorf("unknown policy %s", o.Policy)
+		} else {
+			for _, algorithm := range append(append([]string{}, o.FileAlgorithms...), o.DirectoryAlgorithms...) {
+				if !policy.Allows(algorithm) {
+					err = fmt.Errorf("%w: %s is not allowed by policy %s", ErrPolicyViolation, algorithm, policy.Name)
+				}
+			}
+		}
+	}
+	for _, name := range o.Plugins {
+		pluginInitFunc, ok := optionalPluginMap[name]
+		if !ok {
+			err = fmt.Errorf("unknown plugin %s", name)
+			continue
+		}
+		plugins = append(plugins, pluginInitFunc())
+	}
+
+	for _, plugin := range plugins {
+		if configurable, ok := plugin.(ConfigurablePlugin); ok {
+			configurable.Configure(o)
+		}
+		if algorithmPlugin, ok := plugin.(AlgorithmPlugin); ok && policy != nil {
+			for _, algorithm := range algorithmPlugin.Algorithms() {
+				if !policy.Allows(algorithm) {
+					err = fmt.Errorf("%w: plugin %T uses %s, which policy %s does not allow", ErrPolicyViolation, plugin, algorithm, policy.Name)
+				}
+			}
+		}
+	}
 
 	fmt.Println(plugins)
 
@@ -40,16 +131,19 @@ func NewTrail(option ...Option) Factory {
 		envelope: &Envelope{
 			Header: Header{
 				Features: make(map[string]Feature),
+				Policy:   policy,
 			},
 			Mapping: make(map[string]*Element),
 		},
-		AllowList: allowList,
+		AllowList:   allowList,
+		derivations: make(map[string]*ADGSet),
+		err:         err,
 	}
 
 	return factory
 }
 
-func FormatADGString(mapping Factory) string {
+func FormatADGString(mapping ADGProvider) string {
 	res := ""
 	sha1adgs := mapping.Sha1ADGs()
 	// create a list of all keys sorted in lexical order
@@ -1,14 +1,18 @@
 package omnitrail
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/user"
 	"reflect"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -128,10 +132,40 @@ func testAdd(t *testing.T, name string) error {
 	uid := currentUser.Uid
 	gid := currentUser.Gid
 
-	for _, v := range expectedEnvelope.Mapping {
+	for k, v := range expectedEnvelope.Mapping {
 		v.Posix.OwnerUID = uid
 		v.Posix.OwnerGID = gid
+		// inodes, devices, link counts, file systems and timestamps depend
+		// on the filesystem and the checkout, so only their format is checked
+		if actual, ok := mapping.Envelope().Mapping[k]; ok && actual.Posix != nil {
+			assertHostPosix(t, k, actual.Posix)
+			v.Posix.FileInode = actual.Posix.FileInode
+			v.Posix.FileDeviceID = actual.Posix.FileDeviceID
+			v.Posix.HardLinkCount = actual.Posix.HardLinkCount
+			v.Posix.FileSystemID = actual.Posix.FileSystemID
+			v.Posix.FileSystemType = actual.Posix.FileSystemType
+			// such as SELinux labels
+			v.Posix.ExtendedAttributes = actual.Posix.ExtendedAttributes
+			v.Posix.ATime = actual.Posix.ATime
+			v.Posix.MTime = actual.Posix.MTime
+			v.Posix.CTime = actual.Posix.CTime
+			v.Posix.MetadataCTime = actual.Posix.MetadataCTime
+			v.Posix.CreationTime = actual.Posix.CreationTime
+			if link := actual.Posix.Symlink; link != nil && v.Posix.Symlink != nil {
+				assertHostSymlink(t, k, link)
+				v.Posix.Symlink.FileInode = link.FileInode
+				v.Posix.Symlink.FileDeviceID = link.FileDeviceID
+				v.Posix.Symlink.HardLinkCount = link.HardLinkCount
+				v.Posix.Symlink.OwnerUID = link.OwnerUID
+				v.Posix.Symlink.OwnerGID = link.OwnerGID
+				// only Linux creates every symlink with all permissions
+				if runtime.GOOS != "linux" {
+					v.Posix.Symlink.Permissions = link.Permissions
+				}
+			}
+		}
 	}
+	assertUniqueInodes(t, mapping.Envelope())
 
 	assert.Equal(t, &expectedEnvelope, mapping.Envelope())
 
@@ -151,6 +185,85 @@ func testAdd(t *testing.T, name string) error {
 	return nil
 }
 
+// assertHostPosix checks the posix fields the golden files cannot record, as
+// they depend on the host, the file system and the checkout.
+func assertHostPosix(t *testing.T, path string, posix *Posix) {
+	for name, value := range map[string]string{
+		"file_inode":      posix.FileInode,
+		"file_device_id":  posix.FileDeviceID,
+		"hard_link_count": posix.HardLinkCount,
+	} {
+		n, err := strconv.ParseUint(value, 10, 64)
+		assert.NoError(t, err, "%s: %s", path, name)
+		if name != "file_device_id" {
+			assert.NotZero(t, n, "%s: %s", path, name)
+		}
+	}
+	assert.Regexp(t, "^[0-9a-f]+$", posix.FileSystemID, path)
+	assert.NotEmpty(t, posix.FileSystemType, path)
+
+	times := make(map[string]time.Time)
+	for name, value := range map[string]string{
+		"atime":          posix.ATime,
+		"mtime":          posix.MTime,
+		"ctime":          posix.CTime,
+		"metadata_ctime": posix.MetadataCTime,
+		"creation_time":  posix.CreationTime,
+	} {
+		// not every file system reports birth times
+		if value == "" && name == "creation_time" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		assert.NoError(t, err, "%s: %s", path, name)
+		assert.True(t, strings.HasSuffix(value, "Z"), "%s: %s is not in UTC", path, name)
+		times[name] = parsed
+	}
+	assert.Equal(t, posix.CTime, posix.MetadataCTime, path)
+	if btime, ok := times["creation_time"]; ok {
+		assert.False(t, btime.After(times["ctime"]), "%s: born after its last change", path)
+	}
+
+	for name, value := range posix.ExtendedAttributes {
+		assert.Contains(t, name, ".", "%s: %s has no namespace", path, name)
+		_, err := base64.StdEncoding.DecodeString(value)
+		assert.NoError(t, err, "%s: %s", path, name)
+	}
+}
+
+// assertHostSymlink checks the lstat fields of a symlink the golden files
+// cannot record.
+func assertHostSymlink(t *testing.T, path string, link *PosixSymlink) {
+	for name, value := range map[string]string{
+		"file_inode":      link.FileInode,
+		"file_device_id":  link.FileDeviceID,
+		"hard_link_count": link.HardLinkCount,
+		"owner_uid":       link.OwnerUID,
+		"owner_gid":       link.OwnerGID,
+	} {
+		_, err := strconv.ParseUint(value, 10, 64)
+		assert.NoError(t, err, "%s: symlink %s", path, name)
+	}
+	assert.True(t, strings.HasPrefix(link.Permissions, "L"), "%s: symlink permissions %s", path, link.Permissions)
+}
+
+// assertUniqueInodes checks that entries only share an inode if they are
+// hardlinks, or symlinks describing their target.
+func assertUniqueInodes(t *testing.T, envelope *Envelope) {
+	seen := make(map[string]string)
+	for _, path := range sortedKeys(envelope.Mapping) {
+		posix := envelope.Mapping[path].Posix
+		if posix == nil || posix.Symlink != nil || posix.HardLinkCount != "1" {
+			continue
+		}
+		key := posix.FileDeviceID + ":" + posix.FileInode
+		if other, ok := seen[key]; ok {
+			t.Errorf("%s and %s share inode %s without being linked", other, path, key)
+		}
+		seen[key] = path
+	}
+}
+
 func getShortestKey(expectedEnvelope *Envelope) string {
 	// get map keys
 	keys := make([]string, 0, len(expectedEnvelope.Mapping))
@@ -0,0 +1,203 @@
+package omnitrail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// ErrPolicyViolation is returned for trails with digests their algorithm
+// policy does not allow.
+var ErrPolicyViolation = errors.New("algorithm not allowed by policy")
+
+// Policy restricts the digest algorithms of a trail. The policy a trail was
+// created under is recorded in its header.
+type Policy struct {
+	Name       string   `json:"name"`
+	Algorithms []string `json:"algorithms"`
+}
+
+// FIPSPolicy only allows digests built on the hash functions of FIPS 180-4
+// and FIPS 202, which excludes sha1 and everything derived from it.
+var FIPSPolicy = Policy{
+	Name: "fips",
+	Algorithms: []string{
+		"gitoid:sha256",
+		"gittree:sha256",
+		"nar:sha256",
+		"sha256",
+		"sha3-256",
+		"sha384",
+		"sha512",
+		"sha512/256",
+	},
+}
+
+var (
+	policyLock     sync.RWMutex
+	policyRegistry = map[string]Policy{FIPSPolicy.Name: FIPSPolicy}
+)
+
+// RegisterPolicy makes a policy available to WithPolicy and
+// WithRequiredPolicy. Registering a name again replaces the previous policy.
+func RegisterPolicy(policy Policy) {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	policy.Algorithms = append([]string(nil), policy.Algorithms...)
+	sort.Strings(policy.Algorithms)
+	policyRegistry[policy.Name] = policy
+}
+
+func LookupPolicy(name string) (*Policy, bool) {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+	policy, ok := policyRegistry[name]
+	if !ok {
+		return nil, false
+	}
+	policy.Algorithms = append([]string(nil), policy.Algorithms...)
+	return &policy, true
+}
+
+// WithPolicy restricts the trail to the algorithms of a registered policy,
+// such as "fips". Default algorithms the policy does not allow are dropped,
+// while adding paths fails if a disallowed algorithm was requested
+// explicitly or a plugin produces one.
+func WithPolicy(name string) Option {
+	return func(o *Options) {
+		o.Policy = name
+	}
+}
+
+func (p *Policy) Allows(algorithm string) bool {
+	for _, allowed := range p.Algorithms {
+		if allowed == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the algorithms the policy allows.
+func (p *Policy) filter(algorithms []string) []string {
+	res := make([]string, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		if p.Allows(algorithm) {
+			res = append(res, algorithm)
+		}
+	}
+	return res
+}
+
+// Check returns an ErrPolicyViolation if a feature or element of the
+// envelope uses an algorithm the policy does not allow.
+func (p *Policy) Check(envelope *Envelope) error {
+	for _, name := range sortedFeatures(envelope.Header.Features) {
+		for _, algorithm := range envelope.Header.Features[name].Algorithms {
+			if !p.Allows(algorithm) {
+				return fmt.Errorf("%w: feature %s uses %s, which policy %s does not allow", ErrPolicyViolation, name, algorithm, p.Name)
+			}
+		}
+	}
+	for _, path := range sortedKeys(envelope.Mapping) {
+		digests := elementDigests(envelope.Mapping[path])
+		algorithms := make([]string, 0, len(digests))
+		for algorithm := range digests {
+			algorithms = append(algorithms, algorithm)
+		}
+		sort.Strings(algorithms)
+		for _, algorithm := range algorithms {
+			if !p.Allows(algorithm) {
+				return fmt.Errorf("%w: %s has %s, which policy %s does not allow", ErrPolicyViolation, path, algorithm, p.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func sortedFeatures(features map[string]Feature) []string {
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Policy) equal(other *Policy) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	if p.Name != other.Name || len(p.Algorithms) != len(other.Algorithms) {
+		return false
+	}
+	for i := range p.Algorithms {
+		if p.Algorithms[i] != other.Algorithms[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type LoadOption func(o *LoadOptions)
+
+type LoadOptions struct {
+	// Policy is the name of a registered policy the trail must satisfy
+	Policy string
+}
+
+// WithRequiredPolicy refuses trails that use algorithms the registered
+// policy does not allow, whichever policy their header records.
+func WithRequiredPolicy(name string) LoadOption {
+	return func(o *LoadOptions) {
+		o.Policy = name
+	}
+}
+
+// LoadEnvelope reads a JSON envelope. Envelopes are refused if they use an
+// algorithm the policy named in their header does not allow, or that a
+// policy required by the options does not allow. The policy is looked up in
+// the registry, so a header naming an unknown policy, or recording other
+// algorithms than the registered policy, is refused as well.
+func LoadEnvelope(r io.Reader, option ...LoadOption) (*Envelope, error) {
+	o := &LoadOptions{}
+	for _, opt := range option {
+		opt(o)
+	}
+
+	envelope := &Envelope{}
+	if err := json.NewDecoder(r).Decode(envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Header.Features == nil {
+		envelope.Header.Features = make(map[string]Feature)
+	}
+	if envelope.Mapping == nil {
+		envelope.Mapping = make(map[string]*Element)
+	}
+	if recorded := envelope.Header.Policy; recorded != nil {
+		policy, ok := LookupPolicy(recorded.Name)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown policy %s", ErrPolicyViolation, recorded.Name)
+		}
+		if !policy.equal(recorded) {
+			return nil, fmt.Errorf("%w: recorded algorithms of policy %s differ from the registered policy", ErrPolicyViolation, recorded.Name)
+		}
+		if err := policy.Check(envelope); err != nil {
+			return nil, err
+		}
+	}
+	if o.Policy != "" {
+		policy, ok := LookupPolicy(o.Policy)
+		if !ok {
+			return nil, fmt.Errorf("unknown policy %s", o.Policy)
+		}
+		if err := policy.Check(envelope); err != nil {
+			return nil, err
+		}
+	}
+	return envelope, nil
+}
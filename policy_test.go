@@ -0,0 +1,123 @@
+package omnitrail
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFIPSPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hello")
+	require.NoError(t, os.WriteFile(path, []byte("hello world\n"), 0644))
+
+	trail := NewTrail(WithPolicy("fips"), WithFileAlgorithms("sha384"), WithDirectoryAlgorithms("gittree:sha256"))
+	require.NoError(t, trail.Add(dir))
+	envelope := trail.Envelope()
+	assert.Equal(t, &FIPSPolicy, envelope.Header.Policy)
+	assert.Equal(t, []string{"gitoid:sha256", "sha256", "sha384"}, envelope.Header.Features["file"].Algorithms)
+	assert.Equal(t, []string{"gitoid:sha256", "gittree:sha256"}, envelope.Header.Features["directory"].Algorithms)
+	assert.Empty(t, trail.Sha1ADGs())
+	assert.NotEmpty(t, trail.Sha256ADGs())
+	for path, element := range envelope.Mapping {
+		assert.Empty(t, element.Sha1, path)
+		assert.Empty(t, element.Sha1Gitoid, path)
+		assert.NotEmpty(t, element.Sha256Gitoid, path)
+	}
+
+	b, err := json.Marshal(envelope)
+	require.NoError(t, err)
+	loaded, err := LoadEnvelope(bytes.NewReader(b), WithRequiredPolicy("fips"))
+	require.NoError(t, err)
+	assert.Equal(t, envelope.Header, loaded.Header)
+
+	// a recorded policy is enforced on load
+	envelope.Mapping[path].Sha1 = strings.Repeat("0", 40)
+	b, err = json.Marshal(envelope)
+	require.NoError(t, err)
+	_, err = LoadEnvelope(bytes.NewReader(b))
+	assert.ErrorIs(t, err, ErrPolicyViolation)
+}
+
+func TestForgedPolicy(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hello"), []byte("hello world\n"), 0644))
+	trail := NewTrail()
+	require.NoError(t, trail.Add(dir))
+	envelope := trail.Envelope()
+
+	for name, policy := range map[string]*Policy{
+		"forged algorithms": {Name: "fips", Algorithms: append([]string{"gitoid:sha1", "sha1"}, FIPSPolicy.Algorithms...)},
+		"unknown policy":    {Name: "unknown", Algorithms: []string{"gitoid:sha1", "gitoid:sha256", "sha1", "sha256"}},
+	} {
+		envelope.Header.Policy = policy
+		b, err := json.Marshal(envelope)
+		require.NoError(t, err)
+		_, err = LoadEnvelope(bytes.NewReader(b))
+		assert.ErrorIs(t, err, ErrPolicyViolation, name)
+	}
+}
+
+func TestRequiredPolicy(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hello"), []byte("hello world\n"), 0644))
+	trail := NewTrail()
+	require.NoError(t, trail.Add(dir))
+	b, err := json.Marshal(trail.Envelope())
+	require.NoError(t, err)
+
+	_, err = LoadEnvelope(bytes.NewReader(b))
+	assert.NoError(t, err)
+	_, err = LoadEnvelope(bytes.NewReader(b), WithRequiredPolicy("fips"))
+	assert.ErrorIs(t, err, ErrPolicyViolation)
+	_, err = LoadEnvelope(bytes.NewReader(b), WithRequiredPolicy("unknown"))
+	assert.Error(t, err)
+}
+
+func TestPolicyRejectsAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hello"), []byte("hello world\n"), 0644))
+
+	for name, trail := range map[string]Factory{
+		"explicit file algorithm":      NewTrail(WithPolicy("fips"), WithFileAlgorithms("sha1")),
+		"explicit directory algorithm": NewTrail(WithPolicy("fips"), WithDirectoryAlgorithms("gittree:sha1")),
+		// SWHIDs are sha1 based
+		"plugin":         NewTrail(WithPolicy("fips"), WithPlugins("swhid")),
+		"unknown policy": NewTrail(WithPolicy("unknown")),
+	} {
+		assert.Error(t, trail.Add(dir), name)
+	}
+	assert.ErrorIs(t, NewTrail(WithPolicy("fips"), WithPlugins("swhid")).Add(dir), ErrPolicyViolation)
+
+	// fs-verity digests are sha256 based
+	RegisterPolicy(Policy{Name: "gitoid-only", Algorithms: []string{"gitoid:sha256"}})
+	assert.NoError(t, NewTrail(WithPolicy("gitoid-only")).Add(dir))
+	assert.ErrorIs(t, NewTrail(WithPolicy("gitoid-only"), WithPlugins("fsverity")).Add(dir), ErrPolicyViolation)
+	assert.NoError(t, NewTrail(WithPolicy("gitoid-only"), WithPlugins("ima", "capabilities")).Add(dir))
+}
+
+func TestMergePolicy(t *testing.T) {
+	var envelopes []*Envelope
+	for _, name := range []string{"a", "b"} {
+		dir := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+		trail := NewTrail(WithPolicy("fips"))
+		require.NoError(t, trail.Add(dir))
+		envelopes = append(envelopes, trail.Envelope())
+	}
+	result, err := Merge(envelopes)
+	require.NoError(t, err)
+	assert.Equal(t, &FIPSPolicy, result.Envelope.Header.Policy)
+
+	envelopes[1].Header.Policy = nil
+	result, err = Merge(envelopes)
+	require.NoError(t, err)
+	assert.Nil(t, result.Envelope.Header.Policy)
+}
@@ -0,0 +1,29 @@
+//go:build darwin
+
+package omnitrail
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileSystemOf returns the id and type name of the file system holding path.
+func fileSystemOf(path string, _ uint64) (fileSystem, error) {
+	var statfs unix.Statfs_t
+	if err := unix.Statfs(path, &statfs); err != nil {
+		return fileSystem{}, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	name := make([]byte, 0, len(statfs.Fstypename))
+	for _, c := range statfs.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	return fileSystem{
+		id:  fmt.Sprintf("%x", uint64(uint32(statfs.Fsid.Val[0]))<<32|uint64(uint32(statfs.Fsid.Val[1]))),
+		typ: string(name),
+	}, nil
+}
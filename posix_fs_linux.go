@@ -0,0 +1,81 @@
+//go:build linux
+
+package omnitrail
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileSystemMagics names the file systems of the magic numbers statfs
+// reports, for file systems that cannot be found in the mount table.
+var fileSystemMagics = map[int64]string{
+	0xEF53:     "ext2/ext3/ext4",
+	0x9123683E: "btrfs",
+	0x58465342: "xfs",
+	0x01021994: "tmpfs",
+	0x794C7630: "overlay",
+	0x2FC12FC1: "zfs",
+	0xF2F52010: "f2fs",
+	0xE0F5E1E2: "erofs",
+	0x73717368: "squashfs",
+	0x4D44:     "vfat",
+	0x6969:     "nfs",
+	0xFF534D42: "cifs",
+	0x65735546: "fuse",
+	0x01021997: "9p",
+	0x9FA0:     "proc",
+	0x62656572: "sysfs",
+}
+
+// fileSystemOf returns the id and type name of the file system holding path.
+// The type is looked up by device in the mount table, which tells ext4 from
+// ext2 and names FUSE file systems, and otherwise derived from statfs.
+func fileSystemOf(path string, device uint64) (fileSystem, error) {
+	var statfs unix.Statfs_t
+	if err := unix.Statfs(path, &statfs); err != nil {
+		return fileSystem{}, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	fs := fileSystem{
+		// as printed by stat -f
+		id: fmt.Sprintf("%x", uint64(uint32(statfs.Fsid.Val[0]))<<32|uint64(uint32(statfs.Fsid.Val[1]))),
+	}
+	var ok bool
+	if fs.typ, ok = mountedFileSystemType(device); ok {
+		return fs, nil
+	}
+	if fs.typ, ok = fileSystemMagics[int64(statfs.Type)]; !ok {
+		fs.typ = fmt.Sprintf("0x%x", statfs.Type)
+	}
+	return fs, nil
+}
+
+// mountedFileSystemType returns the type of the file system mounted from a
+// device, as listed in /proc/self/mountinfo.
+func mountedFileSystemType(device uint64) (string, bool) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", false
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	id := fmt.Sprintf("%d:%d", unix.Major(device), unix.Minor(device))
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		mount, super, ok := strings.Cut(scanner.Text(), " - ")
+		fields := strings.Fields(mount)
+		if !ok || len(fields) < 3 || fields[2] != id {
+			continue
+		}
+		if fields := strings.Fields(super); len(fields) > 0 {
+			return fields[0], true
+		}
+	}
+	return "", false
+}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 func init() {
@@ -18,6 +19,18 @@ func init() {
 type PosixPlugin struct {
 	params    map[string]*posixInfo
 	AllowList []string
+	// device -> file system, so statfs and the mount table are read once
+	fileSystems map[uint64]fileSystem
+	// excludeVolatileTimestamps leaves out every timestamp but mtime
+	excludeVolatileTimestamps bool
+	excludeTimestamps         bool
+	xattrs                    xattrFilter
+}
+
+func (p *PosixPlugin) Configure(o *Options) {
+	p.excludeVolatileTimestamps = o.ExcludeVolatileTimestamps
+	p.excludeTimestamps = o.ExcludeTimestamps
+	p.xattrs = newXattrFilter(o)
 }
 
 func (p *PosixPlugin) isAllowedDirectory(path string) bool {
@@ -34,6 +47,58 @@ type posixInfo struct {
 	uid      uint32
 	gid      uint32
 	size     int64
+	inode    uint64
+	device   uint64
+	nlink    uint64
+	times    posixTimes
+	fileType string
+	fs       fileSystem
+	xattrs   map[string]string
+	symlink  *PosixSymlink
+}
+
+type fileSystem struct {
+	id  string
+	typ string
+}
+
+// fileTypeOf names the type of a file.
+func fileTypeOf(mode os.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "regular"
+	case mode.IsDir():
+		return "directory"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "char-device"
+	case mode&os.ModeDevice != 0:
+		return "block-device"
+	}
+	return "unknown"
+}
+
+// posixTimes are the timestamps of a file. btime is zero if the platform or
+// file system does not report birth times.
+type posixTimes struct {
+	atime time.Time
+	mtime time.Time
+	ctime time.Time
+	btime time.Time
+}
+
+// formatTime formats a timestamp in RFC 3339 with nanosecond precision, or
+// returns an empty string for the zero time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
 }
 
 func (p *PosixPlugin) Add(path string) error {
@@ -47,6 +112,7 @@ func (p *PosixPlugin) Add(path string) error {
 		}
 		return err
 	}
+	var symlink *PosixSymlink
 	if localFileInfo.Mode()&os.ModeSymlink != 0 {
 		targetPath, err := os.Readlink(path)
 		if err != nil {
@@ -56,6 +122,16 @@ func (p *PosixPlugin) Add(path string) error {
 			}
 			return err
 		}
+		lstatt := localFileInfo.Sys().(*syscall.Stat_t)
+		symlink = &PosixSymlink{
+			FileDeviceID:  strconv.FormatUint(uint64(lstatt.Dev), 10),
+			FileInode:     strconv.FormatUint(uint64(lstatt.Ino), 10),
+			HardLinkCount: strconv.FormatUint(uint64(lstatt.Nlink), 10),
+			OwnerGID:      strconv.Itoa(int(lstatt.Gid)),
+			OwnerUID:      strconv.Itoa(int(lstatt.Uid)),
+			Permissions:   localFileInfo.Mode().String(),
+			Target:        targetPath,
+		}
 		if !filepath.IsAbs(targetPath) {
 			targetPath = filepath.Join(filepath.Dir(path), targetPath)
 		}
@@ -79,6 +155,27 @@ func (p *PosixPlugin) Add(path string) error {
 	statt := stat.Sys().(*syscall.Stat_t)
 	p.params[path].uid = statt.Uid
 	p.params[path].gid = statt.Gid
+	p.params[path].inode = uint64(statt.Ino)
+	p.params[path].device = uint64(statt.Dev)
+	p.params[path].nlink = uint64(statt.Nlink)
+	if p.params[path].times, err = fileTimes(path, stat); err != nil {
+		return err
+	}
+	// every field describes the target of a symlink, and the link itself is
+	// described by its own lstat
+	p.params[path].fileType = fileTypeOf(perms)
+	p.params[path].symlink = symlink
+	fs, ok := p.fileSystems[uint64(statt.Dev)]
+	if !ok {
+		if fs, err = fileSystemOf(path, uint64(statt.Dev)); err != nil {
+			return err
+		}
+		p.fileSystems[uint64(statt.Dev)] = fs
+	}
+	p.params[path].fs = fs
+	if p.params[path].xattrs, err = readXattrs(path, p.xattrs); err != nil {
+		return err
+	}
 	// if path is a directory, set size to 0
 	if !perms.IsDir() {
 		p.params[path].size = stat.Size()
@@ -87,7 +184,9 @@ func (p *PosixPlugin) Add(path string) error {
 }
 
 func (p *PosixPlugin) Store(envelope *Envelope) error {
-	envelope.Header.Features["posix"] = Feature{}
+	feature := p.xattrs.feature()
+	feature.ExcludeTimestamps = p.excludeTimestamps
+	envelope.Header.Features["posix"] = feature
 	for path, element := range envelope.Mapping {
 		if element.Posix == nil {
 			element.Posix = &Posix{}
@@ -95,9 +194,30 @@ func (p *PosixPlugin) Store(envelope *Envelope) error {
 		element.Posix.Permissions = p.params[path].permMode.String()
 		element.Posix.OwnerUID = strconv.Itoa(int(p.params[path].uid))
 		element.Posix.OwnerGID = strconv.Itoa(int(p.params[path].gid))
+		element.Posix.FileInode = strconv.FormatUint(p.params[path].inode, 10)
+		element.Posix.FileDeviceID = strconv.FormatUint(p.params[path].device, 10)
+		element.Posix.HardLinkCount = strconv.FormatUint(p.params[path].nlink, 10)
+		element.Posix.FileType = p.params[path].fileType
+		element.Posix.FileSystemID = p.params[path].fs.id
+		element.Posix.FileSystemType = p.params[path].fs.typ
+		element.Posix.ExtendedAttributes = p.params[path].xattrs
+		element.Posix.Symlink = p.params[path].symlink
 		if p.params[path].size != 0 {
 			element.Posix.Size = strconv.Itoa(int(p.params[path].size))
 		}
+		times := p.params[path].times
+		if p.excludeTimestamps {
+			continue
+		}
+		element.Posix.MTime = formatTime(times.mtime)
+		if !p.excludeVolatileTimestamps {
+			element.Posix.ATime = formatTime(times.atime)
+			// POSIX only has one change time, the time the inode changed,
+			// which covers both content and metadata changes
+			element.Posix.CTime = formatTime(times.ctime)
+			element.Posix.MetadataCTime = formatTime(times.ctime)
+			element.Posix.CreationTime = formatTime(times.btime)
+		}
 	}
 	return nil
 }
@@ -114,6 +234,8 @@ func (plug *PosixPlugin) SetAllowList(allowList []string) {
 
 func NewPosixPlugin() Plugin {
 	return &PosixPlugin{
-		params: make(map[string]*posixInfo),
+		params:      make(map[string]*posixInfo),
+		fileSystems: make(map[uint64]fileSystem),
+		xattrs:      newXattrFilter(&Options{}),
 	}
 }
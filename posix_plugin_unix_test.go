@@ -0,0 +1,114 @@
+//go:build linux || darwin
+
+package omnitrail
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPosixTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(path, []byte("hello world\n"), 0644))
+	mtime := time.Date(2023, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	atime := time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(path, atime, mtime))
+
+	trail := NewTrail()
+	require.NoError(t, trail.Add(dir))
+	posix := trail.Envelope().Mapping[path].Posix
+	assert.Equal(t, "2023-01-02T03:04:05.123456789Z", posix.MTime)
+	// reading the file may update its access time, depending on the mount
+	accessed, err := time.Parse(time.RFC3339Nano, posix.ATime)
+	require.NoError(t, err)
+	assert.False(t, accessed.Before(atime))
+	ctime, err := time.Parse(time.RFC3339Nano, posix.CTime)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), ctime, time.Minute)
+	assert.Equal(t, posix.CTime, posix.MetadataCTime)
+	if posix.CreationTime != "" {
+		_, err := time.Parse(time.RFC3339Nano, posix.CreationTime)
+		assert.NoError(t, err)
+	}
+
+	trail = NewTrail(WithoutVolatileTimestamps())
+	require.NoError(t, trail.Add(dir))
+	posix = trail.Envelope().Mapping[path].Posix
+	assert.Equal(t, "2023-01-02T03:04:05.123456789Z", posix.MTime)
+	assert.Empty(t, posix.ATime)
+	assert.Empty(t, posix.CTime)
+	assert.Empty(t, posix.MetadataCTime)
+	assert.Empty(t, posix.CreationTime)
+
+	// without any timestamps, a copy with another mtime verifies
+	trail = NewTrail(WithoutTimestamps())
+	require.NoError(t, trail.Add(dir))
+	envelope := trail.Envelope()
+	posix = envelope.Mapping[path].Posix
+	for _, timestamp := range []string{posix.ATime, posix.MTime, posix.CTime, posix.MetadataCTime, posix.CreationTime} {
+		assert.Empty(t, timestamp)
+	}
+	assert.True(t, envelope.Header.Features["posix"].ExcludeTimestamps)
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now()))
+	d, err := Verify(envelope)
+	require.NoError(t, err)
+	assert.Empty(t, d.Changes)
+}
+
+func TestPosixFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(path, []byte("hello world\n"), 0644))
+	require.NoError(t, os.Symlink("file", filepath.Join(dir, "link")))
+
+	trail := NewTrail()
+	require.NoError(t, trail.Add(dir))
+	mapping := trail.Envelope().Mapping
+	assert.Equal(t, "directory", mapping[dir].Posix.FileType)
+	assert.Equal(t, "regular", mapping[path].Posix.FileType)
+	// every field but the symlink ones describes the target of the link
+	linkPath := filepath.Join(dir, "link")
+	link := mapping[linkPath].Posix
+	assert.Equal(t, "regular", link.FileType)
+	assert.Equal(t, mapping[path].Posix.Permissions, link.Permissions)
+	assert.Equal(t, mapping[path].Posix.FileInode, link.FileInode)
+	assert.Nil(t, mapping[path].Posix.Symlink)
+	require.NotNil(t, link.Symlink)
+	info, err := os.Lstat(linkPath)
+	require.NoError(t, err)
+	lstat := info.Sys().(*syscall.Stat_t)
+	assert.Equal(t, &PosixSymlink{
+		FileDeviceID:  strconv.FormatUint(uint64(lstat.Dev), 10),
+		FileInode:     strconv.FormatUint(uint64(lstat.Ino), 10),
+		HardLinkCount: "1",
+		OwnerGID:      strconv.Itoa(int(lstat.Gid)),
+		OwnerUID:      strconv.Itoa(int(lstat.Uid)),
+		Permissions:   info.Mode().String(),
+		Target:        "file",
+	}, link.Symlink)
+
+	posix := mapping[path].Posix
+	assert.NotEmpty(t, posix.FileSystemType)
+	assert.Equal(t, posix.FileSystemID, mapping[dir].Posix.FileSystemID)
+	out, err := exec.Command("stat", "-f", "-c", "%i", path).Output()
+	if err != nil {
+		t.Skipf("GNU stat is not available: %v", err)
+	}
+	assert.Equal(t, strings.TrimSpace(string(out)), posix.FileSystemID)
+	out, err = exec.Command("df", "--output=fstype", path).Output()
+	if err != nil {
+		t.Skipf("GNU df is not available: %v", err)
+	}
+	lines := strings.Fields(string(out))
+	assert.Equal(t, lines[len(lines)-1], posix.FileSystemType)
+}
@@ -0,0 +1,20 @@
+//go:build darwin
+
+package omnitrail
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileTimes returns the timestamps of a file, including its birth time.
+func fileTimes(_ string, info os.FileInfo) (posixTimes, error) {
+	statt := info.Sys().(*syscall.Stat_t)
+	return posixTimes{
+		atime: time.Unix(statt.Atimespec.Unix()),
+		mtime: time.Unix(statt.Mtimespec.Unix()),
+		ctime: time.Unix(statt.Ctimespec.Unix()),
+		btime: time.Unix(statt.Birthtimespec.Unix()),
+	}, nil
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+package omnitrail
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileTimes returns the timestamps of a file. The birth time is read with
+// statx, and is zero on kernels and file systems that do not report it.
+// Without statx the times of info are used instead.
+func fileTimes(path string, info os.FileInfo) (posixTimes, error) {
+	var stx unix.Statx_t
+	err := unix.Statx(unix.AT_FDCWD, path, unix.AT_STATX_SYNC_AS_STAT, unix.STATX_BASIC_STATS|unix.STATX_BTIME, &stx)
+	if statxUnavailable(err) {
+		statt := info.Sys().(*syscall.Stat_t)
+		return posixTimes{
+			atime: time.Unix(statt.Atim.Unix()),
+			mtime: time.Unix(statt.Mtim.Unix()),
+			ctime: time.Unix(statt.Ctim.Unix()),
+		}, nil
+	}
+	if err != nil {
+		return posixTimes{}, &os.PathError{Op: "statx", Path: path, Err: err}
+	}
+	times := posixTimes{
+		atime: statxTime(stx.Atime),
+		mtime: statxTime(stx.Mtime),
+		ctime: statxTime(stx.Ctime),
+	}
+	if stx.Mask&unix.STATX_BTIME != 0 {
+		times.btime = statxTime(stx.Btime)
+	}
+	return times, nil
+}
+
+// statxUnavailable reports whether statx failed because the kernel lacks it,
+// or because a seccomp filter or file system refuses it.
+func statxUnavailable(err error) bool {
+	return errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EPERM) || errors.Is(err, unix.EOPNOTSUPP)
+}
+
+func statxTime(ts unix.StatxTimestamp) time.Time {
+	return time.Unix(ts.Sec, int64(ts.Nsec))
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package omnitrail
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestStatxUnavailable(t *testing.T) {
+	for _, err := range []error{unix.ENOSYS, unix.EPERM, unix.EOPNOTSUPP} {
+		assert.True(t, statxUnavailable(err), err)
+	}
+	assert.False(t, statxUnavailable(nil))
+	assert.False(t, statxUnavailable(unix.ENOENT))
+	assert.False(t, statxUnavailable(&os.PathError{Op: "statx", Path: "missing", Err: unix.EACCES}))
+}
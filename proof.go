@@ -0,0 +1,116 @@
+package omnitrail
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidProof = errors.New("invalid inclusion proof")
+
+// InclusionProof shows that an artifact is part of a directory without the
+// rest of the envelope. ADGs holds the bodies of the ADGs from the parent of
+// the artifact up to the root directory; each of them references the
+// identity of the previous one, starting with the leaf gitoid.
+type InclusionProof struct {
+	Algorithm string   `json:"algorithm"`
+	Leaf      string   `json:"leaf"`
+	ADGs      []string `json:"adgs"`
+}
+
+// NewInclusionProof builds a proof for the entry at path using the
+// "gitoid:sha1" or "gitoid:sha256" ADGs of the envelope. A relative path is
+// resolved against the root of the envelope. The ADGs are recomputed from
+// the mapping and must match the recorded directory gitoids.
+func NewInclusionProof(envelope *Envelope, path string, algorithm string) (*InclusionProof, error) {
+	if _, ok := envelope.Mapping[path]; !ok && !filepath.IsAbs(path) {
+		if root := envelopeRoot(envelope.Mapping); root != "" {
+			path = absoluteKey(root, path)
+		}
+	}
+	element, ok := envelope.Mapping[path]
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the envelope", path)
+	}
+	proof := &InclusionProof{
+		Algorithm: algorithm,
+		Leaf:      elementGitoid(element, algorithm),
+		ADGs:      []string{},
+	}
+	if proof.Leaf == "" {
+		return nil, fmt.Errorf("%s has no %s", path, algorithm)
+	}
+
+	var directories []string
+	for key, e := range envelope.Mapping {
+		if e.Type == "directory" {
+			directories = append(directories, key)
+		}
+	}
+	trees, err := buildADGs(envelope.Mapping, directories, algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	for child, dir := path, filepath.Dir(path); dir != child; child, dir = dir, filepath.Dir(dir) {
+		tree, ok := trees[dir]
+		if !ok {
+			break
+		}
+		if recorded := elementGitoid(envelope.Mapping[dir], algorithm); recorded != tree.Identity() {
+			return nil, fmt.Errorf("%s of %s is %s, but its ADG has gitoid %s", algorithm, dir, recorded, tree.Identity())
+		}
+		proof.ADGs = append(proof.ADGs, tree.String())
+	}
+	return proof, nil
+}
+
+// Root returns the gitoid the proof leads to.
+func (p *InclusionProof) Root() string {
+	if len(p.ADGs) == 0 {
+		return p.Leaf
+	}
+	return adgIdentity(p.ADGs[len(p.ADGs)-1], p.Algorithm == "gitoid:sha256")
+}
+
+// Verify checks that the leaf of the proof is contained in the directory or
+// ADG with the given gitoid.
+func (p *InclusionProof) Verify(root string) error {
+	hashLength := 0
+	switch p.Algorithm {
+	case "gitoid:sha1":
+		hashLength = 40
+	case "gitoid:sha256":
+		hashLength = 64
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidProof, p.Algorithm)
+	}
+	if err := checkHex(p.Leaf, hashLength); err != nil {
+		return fmt.Errorf("%w: invalid leaf: %v", ErrInvalidProof, err)
+	}
+
+	current := p.Leaf
+	for i, adg := range p.ADGs {
+		if adg == "" || !strings.HasSuffix(adg, "\n") {
+			return fmt.Errorf("%w: ADG %d is malformed", ErrInvalidProof, i)
+		}
+		found := false
+		for _, line := range strings.Split(strings.TrimSuffix(adg, "\n"), "\n") {
+			if err := checkReference(line, hashLength); err != nil {
+				return fmt.Errorf("%w: ADG %d: %v", ErrInvalidProof, i, err)
+			}
+			if strings.Split(line, " ")[1] == current {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: ADG %d does not reference %s", ErrInvalidProof, i, current)
+		}
+		current = adgIdentity(adg, hashLength == 64)
+	}
+	if current != root {
+		return fmt.Errorf("%w: proof leads to %s, not %s", ErrInvalidProof, current, root)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package omnitrail
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInclusionProof(t *testing.T) {
+	envelope := rebase(loadEnvelope(t, "deep"), "/deep")
+	adgs := loadADGs(t, "deep")
+
+	proof, err := NewInclusionProof(envelope, "dir1/dir2/file2.txt", "gitoid:sha256")
+	require.NoError(t, err)
+	assert.Equal(t, "73bae245b03a7c9d540831beca1987b2a34f8bec948d032132d09224b613d186", proof.Leaf)
+	assert.Equal(t, []string{
+		adgs.Sha256["4dc2f9fa74bb7761434f4994c26b52d1b8c27661f19dba63d225749b5a5a60f3"],
+		adgs.Sha256["188f8b3bc7cbce45e6bb5c2063c9733eb3a18ddcd3f593556b9a8626961b0f77"],
+		adgs.Sha256[deepRoot],
+	}, proof.ADGs)
+	assert.Equal(t, deepRoot, proof.Root())
+	assert.NoError(t, proof.Verify(deepRoot))
+
+	b, err := json.Marshal(proof)
+	require.NoError(t, err)
+	var decoded InclusionProof
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	assert.NoError(t, decoded.Verify(deepRoot))
+
+	// a proof for a subdirectory leads to the same root
+	proof, err = NewInclusionProof(envelope, "/deep/dir1", "gitoid:sha1")
+	require.NoError(t, err)
+	assert.Len(t, proof.ADGs, 1)
+	assert.NoError(t, proof.Verify("afc6c552cd2595009cf7847777ad5897d0abe46a"))
+
+	proof, err = NewInclusionProof(envelope, ".", "gitoid:sha256")
+	require.NoError(t, err)
+	assert.Empty(t, proof.ADGs)
+	assert.NoError(t, proof.Verify(deepRoot))
+
+	_, err = NewInclusionProof(envelope, "missing.txt", "gitoid:sha256")
+	assert.Error(t, err)
+	envelope.Mapping["/deep/root.txt"].Sha256Gitoid = "0000000000000000000000000000000000000000000000000000000000000000"
+	_, err = NewInclusionProof(envelope, "dir1/file1.txt", "gitoid:sha256")
+	assert.Error(t, err)
+}
+
+func TestInclusionProofTampered(t *testing.T) {
+	envelope := rebase(loadEnvelope(t, "deep"), "/deep")
+	valid, err := NewInclusionProof(envelope, "dir1/file1.txt", "gitoid:sha256")
+	require.NoError(t, err)
+
+	tests := map[string]func(p *InclusionProof){
+		"leaf": func(p *InclusionProof) {
+			p.Leaf = "520f6e3c5ab9d63a4b3acd9bb69d90aad62dd0362d35355d47fcda9f1b70be3e"
+		},
+		"algorithm": func(p *InclusionProof) {
+			p.Algorithm = "gitoid:sha1"
+		},
+		"order": func(p *InclusionProof) {
+			p.ADGs[0], p.ADGs[1] = p.ADGs[1], p.ADGs[0]
+		},
+		"truncated": func(p *InclusionProof) {
+			p.ADGs = p.ADGs[:1]
+		},
+		"malformed": func(p *InclusionProof) {
+			p.ADGs[0] += "tree 1234\n"
+		},
+	}
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			proof := *valid
+			proof.ADGs = append([]string{}, valid.ADGs...)
+			tamper(&proof)
+			assert.ErrorIs(t, proof.Verify(deepRoot), ErrInvalidProof)
+		})
+	}
+	assert.ErrorIs(t, valid.Verify("afc6c552cd2595009cf7847777ad5897d0abe46a"), ErrInvalidProof)
+}
@@ -0,0 +1,215 @@
+package omnitrail
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"path"
+	"sort"
+	"strings"
+)
+
+// contentAlgorithms lists the digests used to pair removed and added entries
+// with identical content, in order of preference.
+var contentAlgorithms = []string{"gitoid:sha256", "sha256", "gitoid:sha1", "sha1"}
+
+// contentKey returns the preferred content identity of an element.
+func contentKey(e *Element) string {
+	digests := elementDigests(e)
+	for _, algorithm := range contentAlgorithms {
+		if digest, ok := digests[algorithm]; ok {
+			return algorithm + ":" + digest
+		}
+	}
+	return ""
+}
+
+// layoutDigests returns, for every path of a relative mapping, a digest of the
+// names of all entries below it. ADGs do not include names, so two directories
+// are only known to be identical if both their gitoids and layouts match.
+func layoutDigests(mapping map[string]*Element) map[string]string {
+	hashers := make(map[string]hash.Hash)
+	for _, p := range sortedKeys(mapping) {
+		for parent, ok := parentPath(p); ok; parent, ok = parentPath(parent) {
+			if _, exists := mapping[parent]; !exists {
+				continue
+			}
+			if _, exists := hashers[parent]; !exists {
+				hashers[parent] = sha256.New()
+			}
+			fmt.Fprintf(hashers[parent], "%s\n", strings.TrimPrefix(p, parent+"/"))
+		}
+	}
+	res := make(map[string]string, len(hashers))
+	for p, h := range hashers {
+		res[p] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+	return res
+}
+
+// detectMoves replaces pairs of removed and added entries with identical
+// content by renames, moves and copies. Directories are only paired if both
+// their gitoids and their layouts match.
+func (d *EnvelopeDiff) detectMoves(oldMapping, newMapping map[string]*Element) {
+	removed := make(map[string]bool)
+	added := make(map[string]bool)
+	rest := []Change{}
+	for _, change := range d.Changes {
+		switch change.Kind {
+		case ChangeRemoved:
+			removed[change.Path] = true
+		case ChangeAdded:
+			added[change.Path] = true
+		default:
+			rest = append(rest, change)
+		}
+	}
+
+	// directories moved wholesale, shallowest first so nested directories
+	// are covered by the move of their parent
+	oldParents := parents(oldMapping)
+	newParents := parents(newMapping)
+	oldLayout := layoutDigests(oldMapping)
+	newLayout := layoutDigests(newMapping)
+	addedDirs := make(map[string][]string)
+	for _, p := range sortedSet(added) {
+		if newMapping[p].Type == "directory" && newParents[p] {
+			key := contentKey(newMapping[p]) + " " + newLayout[p]
+			addedDirs[key] = append(addedDirs[key], p)
+		}
+	}
+	removedDirs := sortedSet(removed)
+	sort.SliceStable(removedDirs, func(i, j int) bool {
+		return strings.Count(removedDirs[i], "/") < strings.Count(removedDirs[j], "/")
+	})
+	for _, from := range removedDirs {
+		element := oldMapping[from]
+		if !removed[from] || element.Type != "directory" || !oldParents[from] {
+			continue
+		}
+		key := contentKey(element)
+		if key == "" {
+			continue
+		}
+		key += " " + oldLayout[from]
+		candidates := addedDirs[key]
+		to := ""
+		for i, candidate := range candidates {
+			if added[candidate] {
+				to = candidate
+				addedDirs[key] = append(candidates[:i:i], candidates[i+1:]...)
+				break
+			}
+		}
+		if to == "" {
+			continue
+		}
+		move := Change{Path: to, Kind: ChangeMoved, From: from, Old: element, New: newMapping[to]}
+		if change, ok := compareElements(to, element, newMapping[to]); ok {
+			move.Features = change.Features
+		}
+		delete(removed, from)
+		delete(added, to)
+		for _, p := range sortedSet(removed) {
+			if !strings.HasPrefix(p, from+"/") {
+				continue
+			}
+			suffix := strings.TrimPrefix(p, from)
+			if !added[to+suffix] {
+				continue
+			}
+			delete(removed, p)
+			delete(added, to+suffix)
+			move.moved = append(move.moved, suffix)
+			if change, ok := compareElements(to+suffix, oldMapping[p], newMapping[to+suffix]); ok {
+				change.From = p
+				rest = append(rest, change)
+			}
+		}
+		rest = append(rest, move)
+	}
+
+	// files renamed or copied
+	oldByContent := make(map[string][]string)
+	for _, p := range sortedKeys(oldMapping) {
+		if key := contentKey(oldMapping[p]); key != "" && oldMapping[p].Type != "directory" {
+			oldByContent[key] = append(oldByContent[key], p)
+		}
+	}
+	removedByContent := make(map[string][]string)
+	for _, p := range sortedSet(removed) {
+		if key := contentKey(oldMapping[p]); key != "" && oldMapping[p].Type != "directory" {
+			removedByContent[key] = append(removedByContent[key], p)
+		}
+	}
+	addedByContent := make(map[string][]string)
+	for _, p := range sortedSet(added) {
+		if key := contentKey(newMapping[p]); key != "" && newMapping[p].Type != "directory" && len(oldByContent[key]) > 0 {
+			addedByContent[key] = append(addedByContent[key], p)
+		}
+	}
+	for key, targets := range addedByContent {
+		// pair renames keeping their file name first, then the rest in order
+		sources := make(map[string]string)
+		for _, sameName := range []bool{true, false} {
+			for _, to := range targets {
+				if _, ok := sources[to]; ok {
+					continue
+				}
+				for i, from := range removedByContent[key] {
+					if !sameName || path.Base(from) == path.Base(to) {
+						sources[to] = from
+						removedByContent[key] = append(removedByContent[key][:i:i], removedByContent[key][i+1:]...)
+						break
+					}
+				}
+			}
+		}
+		for _, to := range targets {
+			kind, from := ChangeRenamed, sources[to]
+			if from == "" {
+				kind, from = ChangeCopied, oldByContent[key][0]
+			}
+			delete(removed, from)
+			delete(added, to)
+			change, ok := compareElements(to, oldMapping[from], newMapping[to])
+			if !ok {
+				change = Change{Path: to, Old: oldMapping[from], New: newMapping[to]}
+			}
+			change.Kind = kind
+			change.From = from
+			rest = append(rest, change)
+		}
+	}
+
+	for p := range removed {
+		rest = append(rest, Change{Path: p, Kind: ChangeRemoved, Old: oldMapping[p]})
+	}
+	for p := range added {
+		rest = append(rest, Change{Path: p, Kind: ChangeAdded, New: newMapping[p]})
+	}
+	sort.SliceStable(rest, func(i, j int) bool {
+		return rest[i].Path < rest[j].Path
+	})
+	d.Changes = rest
+}
+
+// parents returns the set of paths that have at least one child.
+func parents(mapping map[string]*Element) map[string]bool {
+	res := make(map[string]bool)
+	for p := range mapping {
+		if parent, ok := parentPath(p); ok {
+			res[parent] = true
+		}
+	}
+	return res
+}
+
+func sortedSet(set map[string]bool) []string {
+	res := make([]string, 0, len(set))
+	for k := range set {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,126 @@
+package omnitrail
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var ErrADGNotFound = errors.New("ADG not found")
+
+// ADGResolver loads the body of an ADG by its sha1 or sha256 gitoid.
+type ADGResolver interface {
+	ResolveADG(gitoid string) (string, error)
+}
+
+func (s *ADGSet) ResolveADG(gitoid string) (string, error) {
+	adgs := s.Sha1
+	if len(gitoid) == 64 {
+		adgs = s.Sha256
+	}
+	if adg := adgs[gitoid]; adg != "" {
+		return adg, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrADGNotFound, gitoid)
+}
+
+// OmniBORStore stores ADGs as individual objects in the OmniBOR directory
+// layout, for example .omnibor/objects/gitoid_blob_sha256/ab/cdef...
+type OmniBORStore struct {
+	root string
+}
+
+// NewOmniBORStore returns a store rooted at dir, usually a ".omnibor"
+// directory.
+func NewOmniBORStore(dir string) *OmniBORStore {
+	return &OmniBORStore{root: dir}
+}
+
+func (s *OmniBORStore) objectPath(gitoid string) (string, error) {
+	hashType := ""
+	switch len(gitoid) {
+	case 40:
+		hashType = "sha1"
+	case 64:
+		hashType = "sha256"
+	}
+	if hashType == "" || checkHex(gitoid, len(gitoid)) != nil {
+		return "", fmt.Errorf("invalid gitoid %q", gitoid)
+	}
+	return filepath.Join(s.root, "objects", "gitoid_blob_"+hashType, gitoid[:2], gitoid[2:]), nil
+}
+
+// Write stores every ADG of the provider that is not in the store yet and
+// returns the number of objects written. Entries without an ADG, such as
+// file gitoids, are skipped.
+func (s *OmniBORStore) Write(adgs ADGProvider) (int, error) {
+	written := 0
+	for _, m := range []map[string]string{adgs.Sha1ADGs(), adgs.Sha256ADGs()} {
+		for gitoid, adg := range m {
+			if adg == "" {
+				continue
+			}
+			path, err := s.objectPath(gitoid)
+			if err != nil {
+				return written, err
+			}
+			if _, err := os.Stat(path); err == nil {
+				continue
+			} else if !os.IsNotExist(err) {
+				return written, err
+			}
+			if err := writeObject(path, adg); err != nil {
+				return written, err
+			}
+			written++
+		}
+	}
+	return written, nil
+}
+
+// writeObject writes to a temporary file first, so readers never observe a
+// partially written object.
+func writeObject(path string, content string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.WriteString(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ResolveADG reads an ADG from the store and checks it against its gitoid.
+func (s *OmniBORStore) ResolveADG(gitoid string) (string, error) {
+	path, err := s.objectPath(gitoid)
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w: %s", ErrADGNotFound, gitoid)
+	}
+	if err != nil {
+		return "", err
+	}
+	if actual := adgIdentity(string(b), len(gitoid) == 64); actual != gitoid {
+		return "", fmt.Errorf("object %s has gitoid %s", path, actual)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,46 @@
+package omnitrail
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOmniBORStore(t *testing.T) {
+	b, err := os.ReadFile("./test/deep.adg")
+	require.NoError(t, err)
+	adgs, err := ParseADGString(string(b))
+	require.NoError(t, err)
+
+	store := NewOmniBORStore(filepath.Join(t.TempDir(), ".omnibor"))
+	written, err := store.Write(adgs)
+	require.NoError(t, err)
+	assert.Equal(t, 6, written)
+
+	// existing objects are skipped
+	written, err = store.Write(adgs)
+	require.NoError(t, err)
+	assert.Equal(t, 0, written)
+
+	path := filepath.Join(store.root, "objects", "gitoid_blob_sha256", "3f", "8d7b5b500f4db43c40c4586f754e4702c1a7a3d61509867f1af37fae04fa18")
+	assert.FileExists(t, path)
+
+	for _, m := range []map[string]string{adgs.Sha1, adgs.Sha256} {
+		for gitoid, expected := range m {
+			actual, err := store.ResolveADG(gitoid)
+			require.NoError(t, err)
+			assert.Equal(t, expected, actual)
+		}
+	}
+
+	_, err = store.ResolveADG("93ca1422a8da0a9effc465eccbcb17e23015542d")
+	assert.True(t, errors.Is(err, ErrADGNotFound))
+
+	require.NoError(t, os.WriteFile(path, []byte("blob 00\n"), 0644))
+	_, err = store.ResolveADG("3f8d7b5b500f4db43c40c4586f754e4702c1a7a3d61509867f1af37fae04fa18")
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+package omnitrail
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// ExtractSubtree returns the entries of an envelope under subtree, rekeyed
+// relative to newRoot. The subtree is either a key of the mapping or a path
+// relative to the root of the envelope. Gitoids do not depend on names or
+// parents, so every digest is carried over unchanged. The ADGs of the
+// extracted directories are rebuilt from the mapping and checked against the
+// recorded directory gitoids; the filesystem is not accessed.
+func ExtractSubtree(envelope *Envelope, subtree string, newRoot string) (*Envelope, *ADGSet, error) {
+	root := subtree
+	if _, ok := envelope.Mapping[root]; !ok {
+		if base := envelopeRoot(envelope.Mapping); base != "" {
+			root = filepath.Join(base, filepath.FromSlash(subtree))
+		}
+	}
+	if _, ok := envelope.Mapping[root]; !ok {
+		return nil, nil, fmt.Errorf("path %s is not in the envelope", subtree)
+	}
+
+	features := make(map[string]Feature, len(envelope.Header.Features))
+	for name, feature := range envelope.Header.Features {
+		feature.Algorithms = append([]string(nil), feature.Algorithms...)
+		feature.XattrAllow = append([]string(nil), feature.XattrAllow...)
+		feature.XattrDeny = append([]string(nil), feature.XattrDeny...)
+		features[name] = feature
+	}
+	extracted := &Envelope{
+		Header:  Header{Features: features, Policy: envelope.Header.Policy},
+		Mapping: make(map[string]*Element),
+	}
+
+	var directories []string
+	for key, element := range envelope.Mapping {
+		if !isWithin(key, root) {
+			continue
+		}
+		newKey := absoluteKey(newRoot, relativeKey(root, key))
+		extracted.Mapping[newKey] = element.clone()
+		if element.Type == "directory" {
+			directories = append(directories, newKey)
+		}
+	}
+
+	adgs := NewADGSet()
+	for _, algorithm := range features["directory"].Algorithms {
+		if _, err := newArtifactTree(algorithm); err != nil {
+			continue
+		}
+		trees, err := buildADGs(extracted.Mapping, directories, algorithm)
+		if err != nil {
+			return nil, nil, err
+		}
+		for key, tree := range trees {
+			if expected := elementGitoid(extracted.Mapping[key], algorithm); expected != tree.Identity() {
+				return nil, nil, fmt.Errorf("%s of %s does not match its contents", algorithm, key)
+			}
+		}
+		adgs.add(algorithm, trees, extracted.Mapping)
+	}
+	return extracted, adgs, nil
+}
@@ -0,0 +1,46 @@
+package omnitrail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractSubtree(t *testing.T) {
+	deep := rebase(loadEnvelope(t, "deep"), "/deep")
+
+	extracted, adgs, err := ExtractSubtree(deep, "dir1", "/lib")
+	require.NoError(t, err)
+	assert.Equal(t, deep.Header, extracted.Header)
+	assert.Equal(t, map[string]*Element{
+		"/lib":                deep.Mapping["/deep/dir1"],
+		"/lib/file1.txt":      deep.Mapping["/deep/dir1/file1.txt"],
+		"/lib/dir2":           deep.Mapping["/deep/dir1/dir2"],
+		"/lib/dir2/file2.txt": deep.Mapping["/deep/dir1/dir2/file2.txt"],
+	}, extracted.Mapping)
+
+	expected := `blob 08219db9b0969fa29cf16fd04df4a63964da0b69
+blob b51452d06f9e8b948089762da313b43973cbd6a6
+`
+	assert.Equal(t, expected, adgs.Sha1ADGs()["f6c2e0f6ac10055527287a21db1ad39424bf3b99"])
+	assert.Len(t, adgs.Sha1ADGs(), 4)
+	assert.Len(t, adgs.Sha256ADGs(), 4)
+	assert.NotContains(t, adgs.Sha1ADGs(), deep.Mapping["/deep"].Sha1Gitoid)
+
+	relative, _, err := ExtractSubtree(deep, "/deep/dir1/dir2", "")
+	require.NoError(t, err)
+	assert.Contains(t, relative.Mapping, ".")
+	assert.Contains(t, relative.Mapping, "file2.txt")
+}
+
+func TestExtractSubtreeInconsistent(t *testing.T) {
+	deep := rebase(loadEnvelope(t, "deep"), "/deep")
+	deep.Mapping["/deep/dir1/file1.txt"].Sha1Gitoid = deep.Mapping["/deep/root.txt"].Sha1Gitoid
+
+	_, _, err := ExtractSubtree(deep, "dir1", "/lib")
+	assert.Error(t, err)
+
+	_, _, err = ExtractSubtree(deep, "missing", "/lib")
+	assert.Error(t, err)
+}
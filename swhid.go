@@ -0,0 +1,135 @@
+package omnitrail
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func init() {
+	RegisterOptionalPlugin("swhid", NewSWHIDPlugin)
+}
+
+// SWHIDPlugin attaches Software Heritage identifiers to every element. Files
+// get a "swh:1:cnt:" identifier, equal to their sha1 gitoid, and directories
+// a "swh:1:dir:" identifier, their git tree id with empty directories kept as
+// Software Heritage archives them. A symlink is archived as a content holding
+// its target, so its identifier is the one of the link rather than of the
+// file it points to.
+type SWHIDPlugin struct {
+	entries   map[string]gitEntry
+	AllowList []string
+}
+
+func NewSWHIDPlugin() Plugin {
+	return &SWHIDPlugin{
+		entries: make(map[string]gitEntry),
+	}
+}
+
+func (plug *SWHIDPlugin) Add(path string) error {
+	fileInfo, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	entry := gitEntry{mode: fileInfo.Mode()}
+	if fileInfo.Mode()&os.ModeSymlink != 0 {
+		if entry.target, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	plug.entries[path] = entry
+	return nil
+}
+
+func (plug *SWHIDPlugin) Store(envelope *Envelope) error {
+	envelope.Header.Features["swhid"] = Feature{}
+	directories, err := buildGitTrees(envelope.Mapping, plug.entries, "gittree:sha1", true)
+	if err != nil {
+		return err
+	}
+	for path, element := range envelope.Mapping {
+		entry, ok := plug.entries[path]
+		if !ok {
+			continue
+		}
+		switch {
+		case entry.mode&os.ModeSymlink != 0:
+			element.SWHID = "swh:1:cnt:" + hex.EncodeToString(gitObjectHash("blob", []byte(entry.target), false))
+		case entry.mode.IsDir():
+			element.SWHID = "swh:1:dir:" + directories[path]
+		case element.Sha1Gitoid != "":
+			element.SWHID = "swh:1:cnt:" + element.Sha1Gitoid
+		}
+	}
+	return nil
+}
+
+// Algorithms returns the algorithms SWHIDs are built on, which are sha1
+// based.
+func (plug *SWHIDPlugin) Algorithms() []string {
+	return []string{"gitoid:sha1", "gittree:sha1"}
+}
+
+func (plug *SWHIDPlugin) Sha1ADG(map[string]string) {}
+
+func (plug *SWHIDPlugin) Sha256ADG(map[string]string) {}
+
+func (plug *SWHIDPlugin) SetAllowList(allowList []string) {
+	plug.AllowList = allowList
+}
+
+// SWHID is a parsed Software Heritage identifier such as
+// "swh:1:cnt:94a9ed024d3859793618152ea559a168bbcbb5e2;origin=https://example.org".
+type SWHID struct {
+	ObjectType string
+	Hash       string
+	// Qualifiers in the order they appear, as "key=value"
+	Qualifiers []string
+}
+
+var swhidObjectTypes = map[string]bool{
+	"cnt": true,
+	"dir": true,
+	"rev": true,
+	"rel": true,
+	"snp": true,
+}
+
+func ParseSWHID(s string) (*SWHID, error) {
+	parts := strings.Split(s, ";")
+	fields := strings.Split(parts[0], ":")
+	if len(fields) != 4 || fields[0] != "swh" {
+		return nil, fmt.Errorf("invalid SWHID %q", s)
+	}
+	if fields[1] != "1" {
+		return nil, fmt.Errorf("unsupported SWHID version %s", fields[1])
+	}
+	if !swhidObjectTypes[fields[2]] {
+		return nil, fmt.Errorf("invalid SWHID object type %s", fields[2])
+	}
+	if err := checkHex(fields[3], 40); err != nil {
+		return nil, fmt.Errorf("invalid SWHID hash: %w", err)
+	}
+	id := &SWHID{ObjectType: fields[2], Hash: fields[3]}
+	for _, qualifier := range parts[1:] {
+		if key, _, ok := strings.Cut(qualifier, "="); !ok || key == "" {
+			return nil, fmt.Errorf("invalid SWHID qualifier %q", qualifier)
+		}
+		id.Qualifiers = append(id.Qualifiers, qualifier)
+	}
+	return id, nil
+}
+
+// Core returns the identifier without qualifiers.
+func (id *SWHID) Core() string {
+	return fmt.Sprintf("swh:1:%s:%s", id.ObjectType, id.Hash)
+}
+
+func (id *SWHID) String() string {
+	return strings.Join(append([]string{id.Core()}, id.Qualifiers...), ";")
+}
@@ -0,0 +1,71 @@
+package omnitrail
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The expected directory identifiers were computed with git mktree, adding
+// the empty directories git write-tree leaves out.
+func TestSWHIDPlugin(t *testing.T) {
+	dir := writeGitLayout(t)
+	trail := NewTrail(WithPlugins("swhid"))
+	require.NoError(t, trail.Add(dir))
+	envelope := trail.Envelope()
+	assert.Contains(t, envelope.Header.Features, "swhid")
+
+	mapping := envelope.Mapping
+	assert.Equal(t, "swh:1:dir:ef9ccc50747c0f343f068f957cad1deb0a8cfd3c", mapping[dir].SWHID)
+	assert.Equal(t, "swh:1:dir:8e27d2de32709ed2e0df8b258a72b0e5d89bd7de", mapping[filepath.Join(dir, "empty")].SWHID)
+	assert.Equal(t, "swh:1:dir:4b825dc642cb6eb9a060e54bf8d69288fbee4904", mapping[filepath.Join(dir, "empty", "nested")].SWHID)
+	assert.Equal(t, "swh:1:dir:de3c35ccecaa3ddc0eb02ea473767b7f351aff10", mapping[filepath.Join(dir, "src")].SWHID)
+	readme := mapping[filepath.Join(dir, "README")]
+	assert.Equal(t, "swh:1:cnt:"+readme.Sha1Gitoid, readme.SWHID)
+	assert.Equal(t, "swh:1:cnt:8178c76d627cade75005b40711b92f4177bc6cfc", readme.SWHID)
+	// symlinks are identified by their target path
+	assert.Equal(t, "swh:1:cnt:21a91f1e6f4bbe1ef52449898e6567d3519963a0", mapping[filepath.Join(dir, "link")].SWHID)
+
+	idx := NewIndex(envelope)
+	it, err := idx.BySWHID("swh:1:dir:de3c35ccecaa3ddc0eb02ea473767b7f351aff10;origin=https://example.org/repo.git")
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "src")}, collect(it))
+
+	assert.Error(t, NewTrail(WithPlugins("missing")).Add(dir))
+}
+
+func TestSWHIDLookupWithoutFeature(t *testing.T) {
+	idx := NewIndex(rebase(loadEnvelope(t, "deep"), "/deep"))
+	it, err := idx.BySWHID("swh:1:cnt:93ca1422a8da0a9effc465eccbcb17e23015542d")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/deep/root.txt"}, collect(it))
+
+	it, err = idx.BySWHID("swh:1:dir:afc6c552cd2595009cf7847777ad5897d0abe46a")
+	require.NoError(t, err)
+	assert.Equal(t, 0, it.Len())
+}
+
+func TestParseSWHID(t *testing.T) {
+	id, err := ParseSWHID("swh:1:cnt:94a9ed024d3859793618152ea559a168bbcbb5e2;origin=https://github.com/example/repo;lines=9-15")
+	require.NoError(t, err)
+	assert.Equal(t, "cnt", id.ObjectType)
+	assert.Equal(t, "94a9ed024d3859793618152ea559a168bbcbb5e2", id.Hash)
+	assert.Equal(t, []string{"origin=https://github.com/example/repo", "lines=9-15"}, id.Qualifiers)
+	assert.Equal(t, "swh:1:cnt:94a9ed024d3859793618152ea559a168bbcbb5e2", id.Core())
+	assert.Equal(t, "swh:1:cnt:94a9ed024d3859793618152ea559a168bbcbb5e2;origin=https://github.com/example/repo;lines=9-15", id.String())
+
+	for _, invalid := range []string{
+		"",
+		"swh:2:cnt:94a9ed024d3859793618152ea559a168bbcbb5e2",
+		"swh:1:xyz:94a9ed024d3859793618152ea559a168bbcbb5e2",
+		"swh:1:cnt:94a9ed024d3859793618152ea559a168bbcbb5e",
+		"swh:1:cnt:94A9ED024D3859793618152EA559A168BBCBB5E2",
+		"swh:1:cnt:94a9ed024d3859793618152ea559a168bbcbb5e2;origin",
+		"gitoid:blob:sha1:94a9ed024d3859793618152ea559a168bbcbb5e2",
+	} {
+		_, err := ParseSWHID(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,76 @@
+package omnitrail
+
+import (
+	"fmt"
+)
+
+// Verify takes a new trail of the root of the envelope and compares it with
+// the envelope. The new trail uses the algorithms, optional plugins, xattr
+// filters, timestamp exclusion and policy recorded in the header; settings
+// the header does not record, such as fs-verity parameters, have to be
+// passed as options.
+//
+// Every entry is compared, as metadata such as extended attributes does not
+// change directory gitoids. Access, change and birth times are ignored, as
+// reading the tree changes them, and so are derivations, which are not a
+// property of the tree.
+func Verify(envelope *Envelope, option ...Option) (*EnvelopeDiff, error) {
+	root := envelopeRoot(envelope.Mapping)
+	if root == "" {
+		return nil, fmt.Errorf("envelope has no single root")
+	}
+
+	var options []Option
+	for name, feature := range envelope.Header.Features {
+		switch name {
+		case "file":
+			options = append(options, WithFileAlgorithms(feature.Algorithms...))
+		case "directory":
+			options = append(options, WithDirectoryAlgorithms(feature.Algorithms...))
+		case "posix":
+			options = append(options, WithXattrAllow(feature.XattrAllow...), WithXattrDeny(feature.XattrDeny...))
+			if feature.ExcludeTimestamps {
+				options = append(options, WithoutTimestamps())
+			}
+		default:
+			if _, ok := optionalPluginMap[name]; ok {
+				options = append(options, WithPlugins(name))
+			}
+		}
+	}
+	if policy := envelope.Header.Policy; policy != nil {
+		options = append(options, WithPolicy(policy.Name))
+	}
+	trail := NewTrail(append(options, option...)...)
+	if err := trail.Add(root); err != nil {
+		return nil, err
+	}
+	return Diff(verifiable(envelope), verifiable(trail.Envelope()), WithExhaustiveDiff()), nil
+}
+
+// verifiable returns a copy of the envelope without the fields Verify
+// ignores.
+func verifiable(envelope *Envelope) *Envelope {
+	features := make(map[string]Feature, len(envelope.Header.Features))
+	for name, feature := range envelope.Header.Features {
+		if name != "derivation" {
+			features[name] = feature
+		}
+	}
+	res := &Envelope{
+		Header:  Header{Features: features, Policy: envelope.Header.Policy},
+		Mapping: make(map[string]*Element, len(envelope.Mapping)),
+	}
+	for path, element := range envelope.Mapping {
+		e := element.clone()
+		e.Derivation = nil
+		if e.Posix != nil {
+			e.Posix.ATime = ""
+			e.Posix.CTime = ""
+			e.Posix.MetadataCTime = ""
+			e.Posix.CreationTime = ""
+		}
+		res.Mapping[path] = e
+	}
+	return res
+}
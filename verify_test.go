@@ -0,0 +1,37 @@
+package omnitrail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	dir := writeGitLayout(t)
+	trail := NewTrail(WithDirectoryAlgorithms("gittree:sha1"), WithFileAlgorithms("sha384"), WithPlugins("swhid"))
+	require.NoError(t, trail.Add(dir))
+	require.NoError(t, trail.AddDerivation(filepath.Join(dir, "README"), []string{filepath.Join(dir, "src")}))
+	envelope := trail.Envelope()
+
+	d, err := Verify(envelope)
+	require.NoError(t, err)
+	assert.Empty(t, d.Changes)
+	assert.Empty(t, d.Features)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package changed\n"), 0644))
+	d, err = Verify(envelope)
+	require.NoError(t, err)
+	kinds := make(map[string]ChangeKind)
+	for _, c := range d.Changes {
+		kinds[c.Path] = c.Kind
+	}
+	assert.Equal(t, ChangeContent, kinds["src/main.go"])
+	assert.Equal(t, ChangeContent, kinds["src"])
+	assert.Equal(t, ChangeContent, kinds["."])
+
+	_, err = Verify(&Envelope{Mapping: map[string]*Element{"/a": {}, "/b": {}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package omnitrail
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// xattrNamespaces are the namespaces of extended attributes that are
+// recorded by default.
+var xattrNamespaces = []string{"security", "system", "trusted", "user"}
+
+// WithXattrAllow only records the extended attributes matching one of the
+// patterns. A pattern is a namespace such as "user", or a full name such as
+// "security.selinux". By default the security, system, trusted and user
+// namespaces are recorded.
+func WithXattrAllow(patterns ...string) Option {
+	return func(o *Options) {
+		o.XattrAllow = append(o.XattrAllow, patterns...)
+	}
+}
+
+// WithXattrDeny leaves out the extended attributes matching one of the
+// patterns, even if they are allowed.
+func WithXattrDeny(patterns ...string) Option {
+	return func(o *Options) {
+		o.XattrDeny = append(o.XattrDeny, patterns...)
+	}
+}
+
+// xattrFilter selects the extended attributes to record.
+type xattrFilter struct {
+	allow []string
+	deny  []string
+}
+
+func newXattrFilter(o *Options) xattrFilter {
+	f := xattrFilter{
+		allow: append([]string(nil), o.XattrAllow...),
+		deny:  append([]string(nil), o.XattrDeny...),
+	}
+	sort.Strings(f.allow)
+	sort.Strings(f.deny)
+	return f
+}
+
+// feature records the filters that were set in the posix feature, so that
+// Verify reads the same extended attributes.
+func (f xattrFilter) feature() Feature {
+	return Feature{XattrAllow: f.allow, XattrDeny: f.deny}
+}
+
+func matchXattr(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if name == pattern || strings.HasPrefix(name, pattern+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (f xattrFilter) includes(name string) bool {
+	allow := f.allow
+	if len(allow) == 0 {
+		allow = xattrNamespaces
+	}
+	return matchXattr(name, allow) && !matchXattr(name, f.deny)
+}
+
+// readXattrs returns the extended attributes of path the filter includes,
+// keyed by name with base64 encoded values, or nil if there are none.
+// Attributes that cannot be read, such as trusted.* without privileges, are
+// left out.
+func readXattrs(path string, filter xattrFilter) (map[string]string, error) {
+	names, err := listXattrs(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "listxattr", Path: path, Err: err}
+	}
+	var res map[string]string
+	for _, name := range names {
+		if !filter.includes(name) {
+			continue
+		}
+		value, ok, err := getXattr(path, name)
+		if errors.Is(err, os.ErrPermission) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+		if !ok {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]string)
+		}
+		res[name] = base64.StdEncoding.EncodeToString(value)
+	}
+	return res, nil
+}
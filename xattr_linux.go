@@ -0,0 +1,69 @@
+//go:build linux
+
+package omnitrail
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// getXattr returns an extended attribute of path, following symlinks, and
+// false if the file does not have it or the file system has no extended
+// attributes.
+func getXattr(path, name string) ([]byte, bool, error) {
+	for {
+		size, err := unix.Getxattr(path, name, nil)
+		if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.ENOTSUP) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		value := make([]byte, size)
+		n, err := unix.Getxattr(path, name, value)
+		// the attribute grew in between
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if errors.Is(err, unix.ENODATA) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		return value[:n], true, nil
+	}
+}
+
+// listXattrs returns the names of the extended attributes of path, following
+// symlinks. Names the caller may not read, such as trusted.* for unprivileged
+// users, are not listed by the kernel.
+func listXattrs(path string) ([]string, error) {
+	for {
+		size, err := unix.Listxattr(path, nil)
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := unix.Listxattr(path, buf)
+		// an attribute was added in between
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, name := range bytes.Split(buf[:n], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
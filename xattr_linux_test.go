@@ -0,0 +1,98 @@
+//go:build linux
+
+package omnitrail
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtendedAttributes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(path, []byte("hello world\n"), 0644))
+	setXattr(t, path, "user.comment", hex.EncodeToString([]byte("hello")))
+	setXattr(t, path, "user.origin", hex.EncodeToString([]byte("build")))
+
+	trail := NewTrail()
+	require.NoError(t, trail.Add(dir))
+	envelope := trail.Envelope()
+	xattrs := envelope.Mapping[path].Posix.ExtendedAttributes
+	assert.Equal(t, "aGVsbG8=", xattrs["user.comment"])
+	assert.Equal(t, "YnVpbGQ=", xattrs["user.origin"])
+
+	for name, tt := range map[string]struct {
+		options  []Option
+		expected []string
+	}{
+		"allow namespace": {[]Option{WithXattrAllow("user")}, []string{"user.comment", "user.origin"}},
+		"allow name":      {[]Option{WithXattrAllow("user.origin")}, []string{"user.origin"}},
+		"deny name":       {[]Option{WithXattrAllow("user"), WithXattrDeny("user.comment")}, []string{"user.origin"}},
+	} {
+		trail := NewTrail(tt.options...)
+		require.NoError(t, trail.Add(dir))
+		var names []string
+		for name := range trail.Envelope().Mapping[path].Posix.ExtendedAttributes {
+			names = append(names, name)
+		}
+		assert.ElementsMatch(t, tt.expected, names, name)
+	}
+
+	// the filters are recorded, so verification reads the same attributes
+	filtered := NewTrail(WithXattrAllow("user"), WithXattrDeny("user.comment"))
+	require.NoError(t, filtered.Add(dir))
+	assert.Equal(t, Feature{XattrAllow: []string{"user"}, XattrDeny: []string{"user.comment"}}, filtered.Envelope().Header.Features["posix"])
+	d, err := Verify(filtered.Envelope())
+	require.NoError(t, err)
+	assert.Empty(t, d.Changes)
+	assert.Empty(t, d.Features)
+
+	// extended attributes do not change gitoids, so verification compares
+	// every entry
+	d, err = Verify(envelope)
+	require.NoError(t, err)
+	assert.Empty(t, d.Changes)
+	setXattr(t, path, "user.comment", hex.EncodeToString([]byte("changed")))
+	d, err = Verify(envelope)
+	require.NoError(t, err)
+	require.Len(t, d.Changes, 1)
+	assert.Equal(t, ChangeMetadata, d.Changes[0].Kind)
+	assert.Equal(t, map[string][]string{"posix": {"posix.extended_attributes.user.comment"}}, d.Changes[0].Features)
+
+	trail = NewTrail()
+	require.NoError(t, trail.Add(dir))
+	d = Diff(envelope, trail.Envelope(), WithExhaustiveDiff())
+	ops, err := d.JSONPatch()
+	require.NoError(t, err)
+	assert.Contains(t, ops, PatchOperation{
+		Op:    "replace",
+		Path:  "/mapping/" + escapeJSONPointer(path) + "/posix/extended_attributes/user.comment",
+		Value: "Y2hhbmdlZA==",
+	})
+
+	// setting security xattrs needs privileges
+	t.Run("security", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(path, []byte("hello world\n"), 0644))
+		setXattr(t, path, "user.origin", hex.EncodeToString([]byte("build")))
+		setXattr(t, path, "security.ima", "0404"+"a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447")
+
+		trail := NewTrail()
+		require.NoError(t, trail.Add(path))
+		xattrs := trail.Envelope().Mapping[path].Posix.ExtendedAttributes
+		assert.Equal(t, "BASpSJBPLw9Hm4+Bl2lLMBhLDS7Rwc0qHsD7hdKZoZKkRw==", xattrs["security.ima"])
+
+		trail = NewTrail(WithXattrAllow("user.origin", "security.ima"))
+		require.NoError(t, trail.Add(path))
+		var names []string
+		for name := range trail.Envelope().Mapping[path].Posix.ExtendedAttributes {
+			names = append(names, name)
+		}
+		assert.ElementsMatch(t, []string{"security.ima", "user.origin"}, names)
+	})
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package omnitrail
+
+// getXattr returns an extended attribute of path. Extended attributes are
+// only read on Linux.
+func getXattr(_, _ string) ([]byte, bool, error) {
+	return nil, false, nil
+}
+
+// listXattrs returns the names of the extended attributes of path. Extended
+// attributes are only read on Linux.
+func listXattrs(_ string) ([]string, error) {
+	return nil, nil
+}